@@ -1,17 +1,59 @@
 package cmd
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/prometheus/prometheus/prompb"
 
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/handler"
 	"github.com/pgillich/prometheus_text-to-remote_write/util"
 )
 
+// Encodings of WriteRequest files
+const (
+	ENCODING_SNAPPY = "snappy"
+	ENCODING_RAW    = "raw"
+)
+
+// Output modes of more WriteRequests (see max-*-per-request options)
+const (
+	// More WriteRequests are rejected
+	SPLIT_NONE = "none"
+	// WriteRequests are written to separate files, the output is a file name pattern with %d (for example request-%03d.pb.snappy)
+	SPLIT_FILES = "files"
+	// WriteRequests are written after each other, prefixed by their length (as varint, similar to the delimited protobuf format)
+	SPLIT_DELIMITED = "delimited"
+)
+
 var convertCmd = &cobra.Command{
 	Use:   "convert",
 	Short: "Convert text to binary, see more info: `prometheus_text-to-remote_write convert -h`",
-	Long: `Convert text from stdin to binary to stdout.
+	Long: `Convert text from stdin (or from input files) to remote_write binary (snappy-compressed protobuf WriteRequest) to stdout.
+Input files are merged to one WriteRequest, compressed input files are detected.
 Example commands:
+curl -s http://localhost:9100/metrics | ./prometheus_text-to-remote_write convert > write_request.pb.snappy
+curl -H 'Content-Encoding: snappy' -H 'Content-Type: application/x-protobuf' \
+	-H 'X-Prometheus-Remote-Write-Version: 0.1.0' --data-binary @write_request.pb.snappy http://localhost:1234/receive
+./prometheus_text-to-remote_write convert --input=a.txt,b.txt.gz --max-samples-per-request=1000 \
+	--split=files --output=request-%03d.pb.snappy
 `,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindCommandFlags(cmd, conf.OPT_INPUT, conf.OPT_INPUT_FORMAT, conf.OPT_LENIENT,
+			conf.OPT_OUTPUT, conf.OPT_OUTPUT_ENCODING, conf.OPT_SPLIT,
+			conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.OPT_MAX_SERIES_PER_REQUEST, conf.OPT_MAX_BYTES_PER_REQUEST,
+			conf.OPT_DUPLICATE_TIMESTAMP,
+		)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		startConvert()
 	},
@@ -19,8 +61,144 @@ Example commands:
 
 func init() {
 	RootCmd.AddCommand(convertCmd)
+
+	convertCmd.PersistentFlags().StringSlice(conf.OPT_INPUT, []string{}, "Input files (default: stdin)")
+	convertCmd.PersistentFlags().String(conf.OPT_INPUT_FORMAT, conf.DEFAULT_INPUT_FORMAT, "Input format: text, openmetrics or protobuf")
+	convertCmd.PersistentFlags().Bool(conf.OPT_LENIENT, conf.DEFAULT_LENIENT, "Skip invalid lines and convert the valid ones")
+	convertCmd.PersistentFlags().String(conf.OPT_OUTPUT, conf.DEFAULT_OUTPUT, "Output file (-: stdout), file name pattern with %d, if split is files")
+	convertCmd.PersistentFlags().String(conf.OPT_OUTPUT_ENCODING, conf.DEFAULT_OUTPUT_ENCODING, "Output encoding: snappy or raw (uncompressed protobuf)")
+	convertCmd.PersistentFlags().String(conf.OPT_SPLIT, conf.DEFAULT_SPLIT, "Output of more WriteRequests: none (rejected), files or delimited (prefixed by varint length)")
+	convertCmd.PersistentFlags().Int(conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.DEFAULT_MAX_SAMPLES_PER_REQUEST, "Max count of samples in a WriteRequest (0: no limit)")
+	convertCmd.PersistentFlags().Int(conf.OPT_MAX_SERIES_PER_REQUEST, conf.DEFAULT_MAX_SERIES_PER_REQUEST, "Max count of series in a WriteRequest (0: no limit)")
+	convertCmd.PersistentFlags().Int(conf.OPT_MAX_BYTES_PER_REQUEST, conf.DEFAULT_MAX_BYTES_PER_REQUEST, "Max size of a snappy-compressed WriteRequest in bytes (0: no limit)")
+	convertCmd.PersistentFlags().String(conf.OPT_DUPLICATE_TIMESTAMP, conf.DEFAULT_DUPLICATE_TIMESTAMP, "Policy for samples of a series with the same timestamp: first, last, max, min, avg or reject")
 }
 
 func startConvert() {
-	util.PrintFatalf("NOT IMPLEMENTED\n")
+	format, err := handler.FormatByName(viper.GetString(conf.OPT_INPUT_FORMAT))
+	if err != nil {
+		util.PrintFatalf("Input format error: %+v\n", err)
+	}
+	output := viper.GetString(conf.OPT_OUTPUT)
+	encoding := viper.GetString(conf.OPT_OUTPUT_ENCODING)
+	if encoding != ENCODING_SNAPPY && encoding != ENCODING_RAW {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_OUTPUT_ENCODING, encoding)
+	}
+	split := viper.GetString(conf.OPT_SPLIT)
+	switch split {
+	case SPLIT_NONE, SPLIT_DELIMITED:
+	case SPLIT_FILES:
+		if output == STDIO || !strings.Contains(output, "%") {
+			util.PrintFatalf("Output must be a file name pattern with %%d, if %s is %s\n", conf.OPT_SPLIT, SPLIT_FILES)
+		}
+	default:
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_SPLIT, split)
+	}
+	duplicates := viper.GetString(conf.OPT_DUPLICATE_TIMESTAMP)
+	if !handler.IsDuplicatePolicy(duplicates) {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_DUPLICATE_TIMESTAMP, duplicates)
+	}
+
+	options := handler.ImportOptions{
+		Format:  format,
+		Lenient: viper.GetBool(conf.OPT_LENIENT),
+		Timestamps: handler.TimestampPolicy{
+			Missing:       handler.MISSING_TIMESTAMP_RECEIVE_TIME,
+			ReceiveTimeMs: time.Now().UnixNano() / int64(time.Millisecond),
+		},
+		Duplicates: duplicates,
+	}
+	inputs := viper.GetStringSlice(conf.OPT_INPUT)
+	if len(inputs) == 0 {
+		inputs = []string{STDIO}
+	}
+	writeRequest, err := convertInputs(inputs, options)
+	if err != nil {
+		util.PrintFatalf("Convert error: %+v\n", err)
+	}
+
+	writeRequests := handler.SplitWriteRequest(writeRequest, handler.BatchLimits{
+		MaxSamples: viper.GetInt(conf.OPT_MAX_SAMPLES_PER_REQUEST),
+		MaxSeries:  viper.GetInt(conf.OPT_MAX_SERIES_PER_REQUEST),
+		MaxBytes:   viper.GetInt(conf.OPT_MAX_BYTES_PER_REQUEST),
+	})
+	if split == SPLIT_NONE && len(writeRequests) > 1 {
+		util.PrintFatalf("Input is split to %d WriteRequests, see %s option\n", len(writeRequests), conf.OPT_SPLIT)
+	}
+	if err := writeWriteRequests(writeRequests, output, encoding, split); err != nil {
+		util.PrintFatalf("Output error: %+v\n", err)
+	}
+}
+
+// Inputs are imported after each other, the series of the WriteRequests are merged by labels
+func convertInputs(inputs []string, options handler.ImportOptions) (*prompb.WriteRequest, error) {
+	writeRequests := []*prompb.WriteRequest{}
+	for _, input := range inputs {
+		reader, err := openInput(input)
+		if err != nil {
+			return nil, err
+		}
+		summary := handler.NewImportSummary()
+		err = handler.Import(reader, options, handler.CollectingStore(&writeRequests), summary)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", input, err.Error())
+		}
+		if errors := summary.Errors; len(errors) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d invalid lines are skipped\n", input, len(errors))
+		}
+	}
+
+	merged, resolved, err := handler.MergeWriteRequests(writeRequests, options.Duplicates)
+	if err != nil {
+		return nil, err
+	}
+	if resolved > 0 {
+		fmt.Fprintf(os.Stderr, "%d duplicate samples are merged\n", resolved)
+	}
+	return merged, nil
+}
+
+func writeWriteRequests(writeRequests []*prompb.WriteRequest, output string, encoding string, split string) error {
+	if split == SPLIT_FILES {
+		for r, writeRequest := range writeRequests {
+			data, err := encodeWriteRequest(writeRequest, encoding)
+			if err != nil {
+				return err
+			}
+			if err := writeOutput(fmt.Sprintf(output, r+1), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var data []byte
+	for _, writeRequest := range writeRequests {
+		requestData, err := encodeWriteRequest(writeRequest, encoding)
+		if err != nil {
+			return err
+		}
+		if split == SPLIT_DELIMITED {
+			data = append(data, proto.EncodeVarint(uint64(len(requestData)))...)
+		}
+		data = append(data, requestData...)
+	}
+	return writeOutput(output, data)
+}
+
+func encodeWriteRequest(writeRequest *prompb.WriteRequest, encoding string) ([]byte, error) {
+	if encoding == ENCODING_RAW {
+		return writeRequest.Marshal()
+	}
+	return handler.EncodeWriteRequest(writeRequest)
+}
+
+// Output "-" is the stdout
+func writeOutput(output string, data []byte) error {
+	if output == STDIO {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(output, data, 0644)
 }
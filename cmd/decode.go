@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/remote"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode",
+	Short: "Decode binary to text, see more info: `prometheus_text-to-remote_write decode -h`",
+	Long: `Decode remote_write binary (snappy-compressed protobuf WriteRequest) from stdin (or from input files)
+to text exposition format with timestamps (or to JSON) to stdout.
+Example commands:
+./prometheus_text-to-remote_write decode < write_request.pb.snappy
+./prometheus_text-to-remote_write decode --input=request-001.pb,request-002.pb --input-encoding=raw --output-format=json
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindCommandFlags(cmd, conf.OPT_INPUT, conf.OPT_INPUT_ENCODING, conf.OPT_SPLIT, conf.OPT_OUTPUT_FORMAT)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		startDecode()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(decodeCmd)
+
+	decodeCmd.PersistentFlags().StringSlice(conf.OPT_INPUT, []string{}, "Input files (default: stdin)")
+	decodeCmd.PersistentFlags().String(conf.OPT_INPUT_ENCODING, conf.DEFAULT_INPUT_ENCODING, "Input encoding: snappy or raw (uncompressed protobuf)")
+	decodeCmd.PersistentFlags().String(conf.OPT_SPLIT, conf.DEFAULT_SPLIT, "Input of more WriteRequests in a file: none or delimited (prefixed by varint length)")
+	decodeCmd.PersistentFlags().String(conf.OPT_OUTPUT_FORMAT, conf.DEFAULT_OUTPUT_FORMAT, "Output format: text or json")
+}
+
+func startDecode() {
+	encoding := viper.GetString(conf.OPT_INPUT_ENCODING)
+	if encoding != ENCODING_SNAPPY && encoding != ENCODING_RAW {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_INPUT_ENCODING, encoding)
+	}
+	split := viper.GetString(conf.OPT_SPLIT)
+	if split != SPLIT_NONE && split != SPLIT_DELIMITED {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_SPLIT, split)
+	}
+	outputFormat := viper.GetString(conf.OPT_OUTPUT_FORMAT)
+	if outputFormat != OUTPUT_FORMAT_TEXT && outputFormat != OUTPUT_FORMAT_JSON {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_OUTPUT_FORMAT, outputFormat)
+	}
+
+	inputs := viper.GetStringSlice(conf.OPT_INPUT)
+	if len(inputs) == 0 {
+		inputs = []string{STDIO}
+	}
+	writeRequests := []*prompb.WriteRequest{}
+	for _, input := range inputs {
+		inputRequests, err := decodeInput(input, encoding == ENCODING_SNAPPY, split == SPLIT_DELIMITED)
+		if err != nil {
+			util.PrintFatalf("Decode error of %s: %+v\n", input, err)
+		}
+		writeRequests = append(writeRequests, inputRequests...)
+	}
+
+	if outputFormat == OUTPUT_FORMAT_JSON {
+		jsonRequests := make([]remote.JSONWriteRequest, 0, len(writeRequests))
+		for _, writeRequest := range writeRequests {
+			jsonRequests = append(jsonRequests, remote.NewJSONWriteRequest(writeRequest))
+		}
+		// Same to the dry run response
+		var obj interface{} = jsonRequests
+		if len(jsonRequests) == 1 {
+			obj = jsonRequests[0]
+		}
+		objJson, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			util.PrintFatalf("JSON error: %+v\n", err)
+		}
+		fmt.Println(string(objJson))
+		return
+	}
+
+	for _, writeRequest := range writeRequests {
+		if err := remote.WriteText(os.Stdout, writeRequest); err != nil {
+			util.PrintFatalf("Output error: %+v\n", err)
+		}
+	}
+}
+
+// Delimited WriteRequests are prefixed by their length (as varint), see convert
+func decodeInput(input string, compressed bool, delimited bool) ([]*prompb.WriteRequest, error) {
+	reader, err := openFile(input)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if !delimited {
+		writeRequest, err := remote.DecodeWriteRequest(reader, compressed)
+		if err != nil {
+			return nil, err
+		}
+		return []*prompb.WriteRequest{writeRequest}, nil
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests := []*prompb.WriteRequest{}
+	for len(data) > 0 {
+		length, varIntBytes := proto.DecodeVarint(data)
+		if varIntBytes == 0 || uint64(len(data)-varIntBytes) < length {
+			return nil, fmt.Errorf("invalid length of WriteRequest %d", len(writeRequests)+1)
+		}
+		end := varIntBytes + int(length)
+		writeRequest, err := remote.DecodeWriteRequest(bytes.NewReader(data[varIntBytes:end]), compressed)
+		if err != nil {
+			return nil, fmt.Errorf("WriteRequest %d: %s", len(writeRequests)+1, err.Error())
+		}
+		writeRequests = append(writeRequests, writeRequest)
+		data = data[end:]
+	}
+	return writeRequests, nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/handler"
+)
+
+// File name of the stdin (input) and the stdout (output)
+const STDIO = "-"
+
+type inputFile struct {
+	io.Reader
+	file *os.File
+	read *countingReader
+}
+
+func (f inputFile) Close() error {
+	if f.file == os.Stdin {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// BytesRead returns the count of bytes read from the file (before decompressing)
+func (f inputFile) BytesRead() int64 {
+	return f.read.n
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// File name "-" is the stdin, the file is read as is
+func openFile(name string) (inputFile, error) {
+	file := os.Stdin
+	if name != STDIO {
+		var err error
+		if file, err = os.Open(name); err != nil {
+			return inputFile{}, err
+		}
+	}
+	read := &countingReader{r: file}
+	return inputFile{Reader: read, file: file, read: read}, nil
+}
+
+// File name "-" is the stdin, compressed files are detected
+func openInput(name string) (inputFile, error) {
+	input, err := openFile(name)
+	if err != nil {
+		return input, err
+	}
+	if input.Reader, err = handler.CompressedReader(input.read, viper.GetInt64(conf.OPT_MAX_DECOMPRESSED_SIZE)); err != nil {
+		input.Close()
+		return input, err
+	}
+	return input, nil
+}
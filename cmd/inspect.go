@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/handler"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Input format of snappy-compressed (or raw) WriteRequest captures, see input-encoding option
+const FORMAT_WRITE_REQUEST = "write-request"
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Print statistics of the input, see more info: `prometheus_text-to-remote_write inspect -h`",
+	Long: `Print statistics of text (or of WriteRequest captures) from stdin (or from input files) as table (or as JSON) to stdout.
+Example commands:
+./prometheus_text-to-remote_write inspect --input=backfill.txt.gz
+./prometheus_text-to-remote_write inspect --input-format=write-request --per-series --output-format=json < write_request.pb.snappy
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindCommandFlags(cmd, conf.OPT_INPUT, conf.OPT_INPUT_FORMAT, conf.OPT_INPUT_ENCODING, conf.OPT_SPLIT,
+			conf.OPT_OUTPUT_FORMAT, conf.OPT_TOP_LABELS, conf.OPT_PER_SERIES,
+		)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		startInspect()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.PersistentFlags().StringSlice(conf.OPT_INPUT, []string{}, "Input files (default: stdin)")
+	inspectCmd.PersistentFlags().String(conf.OPT_INPUT_FORMAT, conf.DEFAULT_INPUT_FORMAT, "Input format: text, openmetrics, protobuf or write-request")
+	inspectCmd.PersistentFlags().String(conf.OPT_INPUT_ENCODING, conf.DEFAULT_INPUT_ENCODING, "Input encoding of write-request format: snappy or raw (uncompressed protobuf)")
+	inspectCmd.PersistentFlags().String(conf.OPT_SPLIT, conf.DEFAULT_SPLIT, "More WriteRequests in a file of write-request format: none or delimited (prefixed by varint length)")
+	inspectCmd.PersistentFlags().String(conf.OPT_OUTPUT_FORMAT, conf.DEFAULT_OUTPUT_FORMAT, "Output format: text (table) or json")
+	inspectCmd.PersistentFlags().Int(conf.OPT_TOP_LABELS, conf.DEFAULT_TOP_LABELS, "Count of listed labels with the highest cardinality (0: all)")
+	inspectCmd.PersistentFlags().Bool(conf.OPT_PER_SERIES, conf.DEFAULT_PER_SERIES, "List statistics of each series")
+}
+
+func startInspect() {
+	inputFormat := viper.GetString(conf.OPT_INPUT_FORMAT)
+	encoding := viper.GetString(conf.OPT_INPUT_ENCODING)
+	if encoding != ENCODING_SNAPPY && encoding != ENCODING_RAW {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_INPUT_ENCODING, encoding)
+	}
+	split := viper.GetString(conf.OPT_SPLIT)
+	if split != SPLIT_NONE && split != SPLIT_DELIMITED {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_SPLIT, split)
+	}
+	outputFormat := viper.GetString(conf.OPT_OUTPUT_FORMAT)
+	if outputFormat != OUTPUT_FORMAT_TEXT && outputFormat != OUTPUT_FORMAT_JSON {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_OUTPUT_FORMAT, outputFormat)
+	}
+
+	inputs := viper.GetStringSlice(conf.OPT_INPUT)
+	if len(inputs) == 0 {
+		inputs = []string{STDIO}
+	}
+	inspector := handler.NewInspector()
+	for _, input := range inputs {
+		if err := inspectInput(input, inputFormat, encoding == ENCODING_SNAPPY, split == SPLIT_DELIMITED, inspector); err != nil {
+			util.PrintFatalf("Inspect error of %s: %+v\n", input, err)
+		}
+	}
+	report := inspector.Report(viper.GetInt(conf.OPT_TOP_LABELS), viper.GetBool(conf.OPT_PER_SERIES))
+
+	if outputFormat == OUTPUT_FORMAT_JSON {
+		reportJson, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			util.PrintFatalf("JSON error: %+v\n", err)
+		}
+		fmt.Println(string(reportJson))
+		return
+	}
+	writeInspectTable(report)
+}
+
+func inspectInput(input string, inputFormat string, compressed bool, delimited bool, inspector *handler.Inspector) error {
+	if inputFormat == FORMAT_WRITE_REQUEST {
+		writeRequests, err := decodeInput(input, compressed, delimited)
+		if err != nil {
+			return err
+		}
+		for _, writeRequest := range writeRequests {
+			inspector.AddWriteRequest(writeRequest)
+		}
+		return nil
+	}
+
+	format, err := handler.FormatByName(inputFormat)
+	if err != nil {
+		return err
+	}
+	reader, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return handler.InspectMetrics(reader, format, inspector)
+}
+
+func writeInspectTable(report handler.InspectReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FAMILIES\tSERIES\tSAMPLES\tWITHOUT_TIMESTAMP\tMIN_TIMESTAMP\tMAX_TIMESTAMP\tDUPLICATE_TIMESTAMPS\tOUT_OF_ORDER")
+	fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%s\t%d\t%d\n", report.Families, report.Series, report.Samples,
+		report.SamplesWithoutTimestamp, formatReportTimestamp(report.MinTimestampMs, report.Samples-report.SamplesWithoutTimestamp),
+		formatReportTimestamp(report.MaxTimestampMs, report.Samples-report.SamplesWithoutTimestamp),
+		report.DuplicateTimestamps, report.OutOfOrderSamples,
+	)
+
+	fmt.Fprintln(w, "\nMETRIC\tTYPE\tSERIES\t"+statsHeader)
+	for _, metric := range report.Metrics {
+		metricType := metric.Type
+		if metricType == "" {
+			metricType = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", metric.Name, metricType, metric.Series, formatStats(metric.InspectStats))
+	}
+
+	fmt.Fprintln(w, "\nLABEL\tVALUES")
+	for _, label := range report.Labels {
+		fmt.Fprintf(w, "%s\t%d\n", label.Name, label.Values)
+	}
+
+	if len(report.SeriesDetails) > 0 {
+		fmt.Fprintln(w, "\nSERIES\t"+statsHeader)
+		for _, series := range report.SeriesDetails {
+			fmt.Fprintf(w, "%s\t%s\n", series.Series, formatStats(series.InspectStats))
+		}
+	}
+	w.Flush()
+}
+
+const statsHeader = "SAMPLES\tWITHOUT_TIMESTAMP\tMIN_TIMESTAMP\tMAX_TIMESTAMP\tTYPICAL_INTERVAL\tDUPLICATE_TIMESTAMPS\tOUT_OF_ORDER"
+
+func formatStats(stats handler.InspectStats) string {
+	timestamped := stats.Samples - stats.SamplesWithoutTimestamp
+	interval := "-"
+	if stats.TypicalIntervalMs > 0 {
+		interval = (time.Duration(stats.TypicalIntervalMs) * time.Millisecond).String()
+	}
+	return fmt.Sprintf("%d\t%d\t%s\t%s\t%s\t%d\t%d", stats.Samples, stats.SamplesWithoutTimestamp,
+		formatReportTimestamp(stats.MinTimestampMs, timestamped), formatReportTimestamp(stats.MaxTimestampMs, timestamped),
+		interval, stats.DuplicateTimestamps, stats.OutOfOrderSamples,
+	)
+}
+
+// "-" is written, if there is no timestamped sample
+func formatReportTimestamp(timestampMs int64, timestamped int) string {
+	if timestamped == 0 {
+		return "-"
+	}
+	return handler.FormatTimestampMs(timestampMs)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/handler"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Output formats of commands
+const (
+	OUTPUT_FORMAT_TEXT = "text"
+	OUTPUT_FORMAT_JSON = "json"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [file...]",
+	Short: "Lint text without sending, see more info: `prometheus_text-to-remote_write lint -h`",
+	Long: `Lint text from files (or from stdin) without sending, the problems are printed to stdout.
+Exit code is 1, if a problem is found.
+Example commands:
+./prometheus_text-to-remote_write lint metrics.txt
+curl -s http://localhost:9100/metrics | ./prometheus_text-to-remote_write lint --output-format=json
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindCommandFlags(cmd, conf.OPT_INPUT_FORMAT, conf.OPT_OUTPUT_FORMAT)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		startLint(args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(lintCmd)
+
+	lintCmd.PersistentFlags().String(conf.OPT_INPUT_FORMAT, conf.DEFAULT_INPUT_FORMAT, "Input format: text, openmetrics or protobuf")
+	lintCmd.PersistentFlags().String(conf.OPT_OUTPUT_FORMAT, conf.DEFAULT_OUTPUT_FORMAT, "Output format: text or json")
+}
+
+// Flags defined by more commands are bound to viper only for the running command
+func bindCommandFlags(cmd *cobra.Command, names ...string) {
+	for _, name := range names {
+		viper.BindPFlag(name, cmd.Flags().Lookup(name))
+	}
+}
+
+func startLint(files []string) {
+	format, err := handler.FormatByName(viper.GetString(conf.OPT_INPUT_FORMAT))
+	if err != nil {
+		util.PrintFatalf("Input format error: %+v\n", err)
+	}
+	outputFormat := viper.GetString(conf.OPT_OUTPUT_FORMAT)
+	if outputFormat != OUTPUT_FORMAT_TEXT && outputFormat != OUTPUT_FORMAT_JSON {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_OUTPUT_FORMAT, outputFormat)
+	}
+	options := handler.LintOptions{
+		Format:    format,
+		MaxSeries: viper.GetInt(conf.OPT_LINT_MAX_SERIES),
+		MaxAge:    viper.GetDuration(conf.OPT_LINT_MAX_AGE),
+		MaxFuture: viper.GetDuration(conf.OPT_LINT_MAX_FUTURE),
+	}
+
+	if len(files) == 0 {
+		files = []string{STDIO}
+	}
+	type fileProblems struct {
+		File     string                `json:"file"`
+		Problems []handler.LintProblem `json:"problems"`
+	}
+	results := []fileProblems{}
+	problemCount := 0
+	for _, file := range files {
+		problems, err := lintFile(file, options)
+		if err != nil {
+			util.PrintFatalf("Lint error of %s: %+v\n", file, err)
+		}
+		results = append(results, fileProblems{File: file, Problems: problems})
+		problemCount += len(problems)
+	}
+
+	if outputFormat == OUTPUT_FORMAT_JSON {
+		resultsJson, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			util.PrintFatalf("JSON error: %+v\n", err)
+		}
+		fmt.Println(string(resultsJson))
+	} else {
+		for _, result := range results {
+			for _, problem := range result.Problems {
+				fmt.Printf("%s: %s\n", result.File, problem)
+			}
+		}
+	}
+
+	if problemCount > 0 {
+		os.Exit(1)
+	}
+}
+
+func lintFile(file string, options handler.LintOptions) ([]handler.LintProblem, error) {
+	reader, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return handler.Lint(reader, options)
+}
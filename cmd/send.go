@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/handler"
+	"github.com/pgillich/prometheus_text-to-remote_write/remote"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send [file or glob...]",
+	Short: "Send text files, see more info: `prometheus_text-to-remote_write send -h`",
+	Long: `Send text files (or stdin) to remote_write, the same way as the service does, compressed files are detected.
+Other options of the service (for example relabel-config-file) can be set by config file or environment variables.
+Progress is reported to stderr, the import summary of each file is printed to stdout.
+Exit code is 1, if a file or a WriteRequest is failed.
+Example commands:
+./prometheus_text-to-remote_write send --write-to=http://localhost:1234/receive --flush-samples=100000 'backfill/*.txt.gz'
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindCommandFlags(cmd, conf.OPT_WRITE_TO, conf.OPT_REMOTE_TIMEOUT, conf.OPT_INPUT_FORMAT, conf.OPT_LENIENT,
+			conf.OPT_FLUSH_SAMPLES, conf.OPT_FLUSH_BYTES,
+			conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.OPT_MAX_SERIES_PER_REQUEST, conf.OPT_MAX_BYTES_PER_REQUEST,
+			conf.OPT_RETRIES, conf.OPT_RETRY_INTERVAL, conf.OPT_PROGRESS_INTERVAL,
+		)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		startSend(args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sendCmd)
+
+	sendCmd.PersistentFlags().String(conf.OPT_WRITE_TO, conf.DEFAULT_WRITE_TO, "Send binary to URL")
+	sendCmd.PersistentFlags().Duration(conf.OPT_REMOTE_TIMEOUT, conf.DEFAULT_REMOTE_TIMEOUT, "Timeout of a WriteRequest")
+	sendCmd.PersistentFlags().String(conf.OPT_INPUT_FORMAT, conf.DEFAULT_INPUT_FORMAT, "Input format: text, openmetrics or protobuf")
+	sendCmd.PersistentFlags().Bool(conf.OPT_LENIENT, conf.DEFAULT_LENIENT, "Skip invalid lines and send the valid ones")
+	sendCmd.PersistentFlags().Int(conf.OPT_FLUSH_SAMPLES, conf.DEFAULT_FLUSH_SAMPLES, "Send series, if the count of parsed samples reaches it (0: no limit)")
+	sendCmd.PersistentFlags().Int64(conf.OPT_FLUSH_BYTES, conf.DEFAULT_FLUSH_BYTES, "Send series, if the count of read bytes reaches it (0: no limit)")
+	sendCmd.PersistentFlags().Int(conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.DEFAULT_MAX_SAMPLES_PER_REQUEST, "Max count of samples in a WriteRequest (0: no limit)")
+	sendCmd.PersistentFlags().Int(conf.OPT_MAX_SERIES_PER_REQUEST, conf.DEFAULT_MAX_SERIES_PER_REQUEST, "Max count of series in a WriteRequest (0: no limit)")
+	sendCmd.PersistentFlags().Int(conf.OPT_MAX_BYTES_PER_REQUEST, conf.DEFAULT_MAX_BYTES_PER_REQUEST, "Max size of a snappy-compressed WriteRequest in bytes (0: no limit)")
+	sendCmd.PersistentFlags().Int(conf.OPT_RETRIES, conf.DEFAULT_RETRIES, "Count of retries of a WriteRequest, if sending is failed by recoverable error")
+	sendCmd.PersistentFlags().Duration(conf.OPT_RETRY_INTERVAL, conf.DEFAULT_RETRY_INTERVAL, "Wait before the first retry, doubled by each retry")
+	sendCmd.PersistentFlags().Duration(conf.OPT_PROGRESS_INTERVAL, conf.DEFAULT_PROGRESS_INTERVAL, "Interval of progress reports (0: only after files)")
+}
+
+// Totals of the sent files
+type sendProgress struct {
+	started    time.Time
+	lastReport time.Time
+	interval   time.Duration
+	files      int
+	bytes      int64
+	series     int
+	samples    int
+	requests   int
+	errors     int
+}
+
+// Current file is added to the totals
+func (p *sendProgress) report(input inputFile, summary *handler.ImportSummary, errors int) {
+	fmt.Fprintf(os.Stderr, "progress: files=%d bytes=%d series=%d samples=%d requests=%d errors=%d elapsed=%s\n",
+		p.files, p.bytes+input.BytesRead(), p.series+summary.Series, p.samples+summary.SamplesSent,
+		p.requests+summary.Requests, p.errors+errors, time.Since(p.started).Round(time.Millisecond),
+	)
+	p.lastReport = time.Now()
+}
+
+func startSend(patterns []string) {
+	format, err := handler.FormatByName(viper.GetString(conf.OPT_INPUT_FORMAT))
+	if err != nil {
+		util.PrintFatalf("Input format error: %+v\n", err)
+	}
+	if err := handler.LoadRelabelConfigs(viper.GetString(conf.OPT_RELABEL_CONFIG_FILE)); err != nil {
+		util.PrintFatalf("Relabel config error: %+v\n", err)
+	}
+	options, err := handler.ServiceImportOptions(format)
+	if err != nil {
+		util.PrintFatalf("Options error: %+v\n", err)
+	}
+	store, err := handler.NewRemoteStore()
+	if err != nil {
+		util.PrintFatalf("Client error: %+v\n", err)
+	}
+	store = retryingStore(store, viper.GetInt(conf.OPT_RETRIES), viper.GetDuration(conf.OPT_RETRY_INTERVAL))
+
+	progress := &sendProgress{
+		started:  time.Now(),
+		interval: viper.GetDuration(conf.OPT_PROGRESS_INTERVAL),
+	}
+	if len(patterns) == 0 {
+		patterns = []string{STDIO}
+	}
+	for _, pattern := range patterns {
+		files := []string{pattern}
+		if pattern != STDIO {
+			if files, err = filepath.Glob(pattern); err != nil || len(files) == 0 {
+				fmt.Fprintf(os.Stderr, "%s: no matching file\n", pattern)
+				progress.errors++
+				continue
+			}
+		}
+		for _, file := range files {
+			sendFile(file, options, store, progress)
+		}
+	}
+
+	if progress.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// The import of a file is stopped by the first failed WriteRequest
+func sendFile(file string, options handler.ImportOptions, store handler.StoreFunc, progress *sendProgress) {
+	input, err := openInput(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+		progress.errors++
+		return
+	}
+	defer input.Close()
+
+	options.Timestamps.ReceiveTimeMs = time.Now().UnixNano() / int64(time.Millisecond)
+	summary := handler.NewImportSummary()
+	reportingStore := func(writeRequest *prompb.WriteRequest) error {
+		if progress.interval > 0 && time.Since(progress.lastReport) >= progress.interval {
+			progress.report(input, summary, 0)
+		}
+		return store(writeRequest)
+	}
+	err = handler.Import(input, options, reportingStore, summary)
+
+	progress.files++
+	errors := 0
+	if err != nil {
+		errors = 1
+	}
+	progress.report(input, summary, errors)
+	progress.bytes += input.BytesRead()
+	progress.series += summary.Series
+	progress.samples += summary.SamplesSent
+	progress.requests += summary.Requests
+	progress.errors += errors
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+		return
+	}
+	fmt.Printf("%s: %s\n", file, summary)
+}
+
+// Recoverable errors (network error or 5xx) are retried, the wait is doubled by each retry
+func retryingStore(store handler.StoreFunc, retries int, interval time.Duration) handler.StoreFunc {
+	return func(writeRequest *prompb.WriteRequest) error {
+		wait := interval
+		for retry := 0; ; retry++ {
+			err := store(writeRequest)
+			if err == nil || !remote.IsRecoverable(err) || retry >= retries {
+				return err
+			}
+			glog.Warningf("%s: retry %d/%d after %s: %+v\n", util.FUNCTION_NAME_SHORT(), retry+1, retries, wait, err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
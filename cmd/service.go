@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/golang/glog"
 
@@ -10,6 +11,7 @@ import (
 
 	"github.com/pgillich/prometheus_text-to-remote_write/conf"
 	"github.com/pgillich/prometheus_text-to-remote_write/handler"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
 )
 
 var serviceCmd = &cobra.Command{
@@ -32,14 +34,93 @@ func init() {
 	serviceCmd.PersistentFlags().String(conf.OPT_RECEIVE_PATH_TEXT, conf.DEFAULT_RECEIVE_PATH_TEXT, "Receive path of text")
 	viper.BindPFlag(conf.OPT_RECEIVE_PATH_TEXT, serviceCmd.PersistentFlags().Lookup(conf.OPT_RECEIVE_PATH_TEXT))
 
+	serviceCmd.PersistentFlags().String(conf.OPT_PUSHGATEWAY_PATH, conf.DEFAULT_PUSHGATEWAY_PATH, "Path prefix of Pushgateway-compatible paths (empty: disabled)")
+	viper.BindPFlag(conf.OPT_PUSHGATEWAY_PATH, serviceCmd.PersistentFlags().Lookup(conf.OPT_PUSHGATEWAY_PATH))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_DRY_RUN_PATH, conf.DEFAULT_DRY_RUN_PATH, "Receive path of dry run, responding the WriteRequests instead of sending (empty: disabled)")
+	viper.BindPFlag(conf.OPT_DRY_RUN_PATH, serviceCmd.PersistentFlags().Lookup(conf.OPT_DRY_RUN_PATH))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_VALIDATE_PATH, conf.DEFAULT_VALIDATE_PATH, "Receive path of validation, responding the lint problems (empty: disabled)")
+	viper.BindPFlag(conf.OPT_VALIDATE_PATH, serviceCmd.PersistentFlags().Lookup(conf.OPT_VALIDATE_PATH))
+
 	serviceCmd.PersistentFlags().String(conf.OPT_WRITE_TO, conf.DEFAULT_WRITE_TO, "Send binary to URL")
 	viper.BindPFlag(conf.OPT_WRITE_TO, serviceCmd.PersistentFlags().Lookup(conf.OPT_WRITE_TO))
+
+	serviceCmd.PersistentFlags().Duration(conf.OPT_REMOTE_TIMEOUT, conf.DEFAULT_REMOTE_TIMEOUT, "Timeout of a WriteRequest")
+	viper.BindPFlag(conf.OPT_REMOTE_TIMEOUT, serviceCmd.PersistentFlags().Lookup(conf.OPT_REMOTE_TIMEOUT))
+
+	serviceCmd.PersistentFlags().Bool(conf.OPT_LENIENT, conf.DEFAULT_LENIENT, "Skip invalid lines and send the valid ones")
+	viper.BindPFlag(conf.OPT_LENIENT, serviceCmd.PersistentFlags().Lookup(conf.OPT_LENIENT))
+
+	serviceCmd.PersistentFlags().Bool(conf.OPT_SANITIZE, conf.DEFAULT_SANITIZE, "Repair invalid metric and label names (replacing invalid characters with _)")
+	viper.BindPFlag(conf.OPT_SANITIZE, serviceCmd.PersistentFlags().Lookup(conf.OPT_SANITIZE))
+
+	serviceCmd.PersistentFlags().Duration(conf.OPT_RETRY_AFTER, conf.DEFAULT_RETRY_AFTER, "Retry-After of response, if sending is failed by recoverable error")
+	viper.BindPFlag(conf.OPT_RETRY_AFTER, serviceCmd.PersistentFlags().Lookup(conf.OPT_RETRY_AFTER))
+
+	serviceCmd.PersistentFlags().Int(conf.OPT_FLUSH_SAMPLES, conf.DEFAULT_FLUSH_SAMPLES, "Send series, if the count of parsed samples reaches it (0: no limit)")
+	viper.BindPFlag(conf.OPT_FLUSH_SAMPLES, serviceCmd.PersistentFlags().Lookup(conf.OPT_FLUSH_SAMPLES))
+
+	serviceCmd.PersistentFlags().Int64(conf.OPT_FLUSH_BYTES, conf.DEFAULT_FLUSH_BYTES, "Send series, if the size of parsed input in bytes reaches it (0: no limit)")
+	viper.BindPFlag(conf.OPT_FLUSH_BYTES, serviceCmd.PersistentFlags().Lookup(conf.OPT_FLUSH_BYTES))
+
+	serviceCmd.PersistentFlags().Int(conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.DEFAULT_MAX_SAMPLES_PER_REQUEST, "Max count of samples in a remote_write request (0: no limit)")
+	viper.BindPFlag(conf.OPT_MAX_SAMPLES_PER_REQUEST, serviceCmd.PersistentFlags().Lookup(conf.OPT_MAX_SAMPLES_PER_REQUEST))
+
+	serviceCmd.PersistentFlags().Int(conf.OPT_MAX_SERIES_PER_REQUEST, conf.DEFAULT_MAX_SERIES_PER_REQUEST, "Max count of series in a remote_write request (0: no limit)")
+	viper.BindPFlag(conf.OPT_MAX_SERIES_PER_REQUEST, serviceCmd.PersistentFlags().Lookup(conf.OPT_MAX_SERIES_PER_REQUEST))
+
+	serviceCmd.PersistentFlags().Int(conf.OPT_MAX_BYTES_PER_REQUEST, conf.DEFAULT_MAX_BYTES_PER_REQUEST, "Max size of a compressed remote_write request in bytes (0: no limit)")
+	viper.BindPFlag(conf.OPT_MAX_BYTES_PER_REQUEST, serviceCmd.PersistentFlags().Lookup(conf.OPT_MAX_BYTES_PER_REQUEST))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_MISSING_TIMESTAMP, conf.DEFAULT_MISSING_TIMESTAMP, "Policy for samples without timestamp: reject, receive-time or base-time")
+	viper.BindPFlag(conf.OPT_MISSING_TIMESTAMP, serviceCmd.PersistentFlags().Lookup(conf.OPT_MISSING_TIMESTAMP))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_TIME_SHIFT, conf.DEFAULT_TIME_SHIFT, "Shift samples by a duration (for example -720h), or map the newest sample to now[-<duration>]")
+	viper.BindPFlag(conf.OPT_TIME_SHIFT, serviceCmd.PersistentFlags().Lookup(conf.OPT_TIME_SHIFT))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_TIME_SHIFT_LABEL, conf.DEFAULT_TIME_SHIFT_LABEL, "Label for recording the applied time shift offset in ms (empty: no label)")
+	viper.BindPFlag(conf.OPT_TIME_SHIFT_LABEL, serviceCmd.PersistentFlags().Lookup(conf.OPT_TIME_SHIFT_LABEL))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_DUPLICATE_TIMESTAMP, conf.DEFAULT_DUPLICATE_TIMESTAMP, "Policy for samples of a series with the same timestamp: first, last, max, min, avg or reject")
+	viper.BindPFlag(conf.OPT_DUPLICATE_TIMESTAMP, serviceCmd.PersistentFlags().Lookup(conf.OPT_DUPLICATE_TIMESTAMP))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_RELABEL_CONFIG_FILE, conf.DEFAULT_RELABEL_CONFIG_FILE, "YAML file of Prometheus relabel_configs, applied on sent series")
+	viper.BindPFlag(conf.OPT_RELABEL_CONFIG_FILE, serviceCmd.PersistentFlags().Lookup(conf.OPT_RELABEL_CONFIG_FILE))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_EXTERNAL_LABELS, conf.DEFAULT_EXTERNAL_LABELS, "Labels added to every series, for example: env=prod,cluster=eu-1")
+	viper.BindPFlag(conf.OPT_EXTERNAL_LABELS, serviceCmd.PersistentFlags().Lookup(conf.OPT_EXTERNAL_LABELS))
+
+	serviceCmd.PersistentFlags().String(conf.OPT_EXTERNAL_LABELS_CONFLICT, conf.DEFAULT_EXTERNAL_LABELS_CONFLICT, "Policy for external labels already on the series: keep, override or reject")
+	viper.BindPFlag(conf.OPT_EXTERNAL_LABELS_CONFLICT, serviceCmd.PersistentFlags().Lookup(conf.OPT_EXTERNAL_LABELS_CONFLICT))
+
+	serviceCmd.PersistentFlags().Duration(conf.OPT_STALE_AFTER, conf.DEFAULT_STALE_AFTER, "Send a staleness marker to each series, this duration after its last sample (0: disabled)")
+	viper.BindPFlag(conf.OPT_STALE_AFTER, serviceCmd.PersistentFlags().Lookup(conf.OPT_STALE_AFTER))
 }
 
 func startListening() {
 	receiveOnAddr := viper.GetString(conf.OPT_RECEIVE_ON)
 
+	if err := handler.LoadRelabelConfigs(viper.GetString(conf.OPT_RELABEL_CONFIG_FILE)); err != nil {
+		util.PrintFatalf("Relabel config error: %+v\n", err)
+	}
+	if err := handler.ParseLabels(viper.GetString(conf.OPT_EXTERNAL_LABELS), map[string]string{}); err != nil {
+		util.PrintFatalf("External labels error: %+v\n", err)
+	}
+	if !handler.IsExternalLabelsConflict(viper.GetString(conf.OPT_EXTERNAL_LABELS_CONFLICT)) {
+		util.PrintFatalf("Invalid %s: %s\n", conf.OPT_EXTERNAL_LABELS_CONFLICT, viper.GetString(conf.OPT_EXTERNAL_LABELS_CONFLICT))
+	}
+
 	http.Handle(viper.GetString(conf.OPT_RECEIVE_PATH_TEXT), http.HandlerFunc(handler.HandlePush))
+	if dryRunPath := viper.GetString(conf.OPT_DRY_RUN_PATH); dryRunPath != "" {
+		http.Handle(dryRunPath, http.HandlerFunc(handler.HandleDryRun))
+	}
+	if validatePath := viper.GetString(conf.OPT_VALIDATE_PATH); validatePath != "" {
+		http.Handle(validatePath, http.HandlerFunc(handler.HandleValidate))
+	}
+	if pushgatewayPath := strings.TrimSuffix(viper.GetString(conf.OPT_PUSHGATEWAY_PATH), "/"); pushgatewayPath != "" {
+		http.Handle(pushgatewayPath+"/", http.StripPrefix(pushgatewayPath, http.HandlerFunc(handler.HandlePushgateway)))
+	}
 
 	glog.Infoln("Receiving on", receiveOnAddr)
 	http.ListenAndServe(receiveOnAddr, nil)
@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/handler"
+	"github.com/pgillich/prometheus_text-to-remote_write/remote"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Error sidecar of a failed file is written next to it, with this suffix
+const ERROR_SUFFIX = ".error"
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <directory>",
+	Short: "Import files of a spool directory, see more info: `prometheus_text-to-remote_write watch -h`",
+	Long: `Watch a spool directory and import each new file to remote_write (the same way as send does), once it's fully written.
+A file is fully written, if it's not changed for settle-time. The directory is also polled, because file events are not sent on NFS.
+Imported files are moved to done-dir, failed files are moved to failed-dir with an error sidecar file (relative to the directory).
+Files failed by a recoverable error (network error or 5xx) are kept and retried by the next poll.
+Example commands:
+./prometheus_text-to-remote_write watch --write-to=http://localhost:1234/receive --flush-samples=100000 /var/spool/metrics
+`,
+	Args: cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindCommandFlags(cmd, conf.OPT_WRITE_TO, conf.OPT_REMOTE_TIMEOUT, conf.OPT_INPUT_FORMAT, conf.OPT_LENIENT,
+			conf.OPT_FLUSH_SAMPLES, conf.OPT_FLUSH_BYTES,
+			conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.OPT_MAX_SERIES_PER_REQUEST, conf.OPT_MAX_BYTES_PER_REQUEST,
+			conf.OPT_RETRIES, conf.OPT_RETRY_INTERVAL,
+			conf.OPT_WATCH_PATTERNS, conf.OPT_SETTLE_TIME, conf.OPT_POLL_INTERVAL, conf.OPT_DONE_DIR, conf.OPT_FAILED_DIR,
+		)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		startWatch(args[0])
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+
+	watchCmd.PersistentFlags().String(conf.OPT_WRITE_TO, conf.DEFAULT_WRITE_TO, "Send binary to URL")
+	watchCmd.PersistentFlags().Duration(conf.OPT_REMOTE_TIMEOUT, conf.DEFAULT_REMOTE_TIMEOUT, "Timeout of a WriteRequest")
+	watchCmd.PersistentFlags().String(conf.OPT_INPUT_FORMAT, conf.DEFAULT_INPUT_FORMAT, "Input format: text, openmetrics or protobuf")
+	watchCmd.PersistentFlags().Bool(conf.OPT_LENIENT, conf.DEFAULT_LENIENT, "Skip invalid lines and send the valid ones")
+	watchCmd.PersistentFlags().Int(conf.OPT_FLUSH_SAMPLES, conf.DEFAULT_FLUSH_SAMPLES, "Send series, if the count of parsed samples reaches it (0: no limit)")
+	watchCmd.PersistentFlags().Int64(conf.OPT_FLUSH_BYTES, conf.DEFAULT_FLUSH_BYTES, "Send series, if the count of read bytes reaches it (0: no limit)")
+	watchCmd.PersistentFlags().Int(conf.OPT_MAX_SAMPLES_PER_REQUEST, conf.DEFAULT_MAX_SAMPLES_PER_REQUEST, "Max count of samples in a WriteRequest (0: no limit)")
+	watchCmd.PersistentFlags().Int(conf.OPT_MAX_SERIES_PER_REQUEST, conf.DEFAULT_MAX_SERIES_PER_REQUEST, "Max count of series in a WriteRequest (0: no limit)")
+	watchCmd.PersistentFlags().Int(conf.OPT_MAX_BYTES_PER_REQUEST, conf.DEFAULT_MAX_BYTES_PER_REQUEST, "Max size of a snappy-compressed WriteRequest in bytes (0: no limit)")
+	watchCmd.PersistentFlags().Int(conf.OPT_RETRIES, conf.DEFAULT_RETRIES, "Count of retries of a WriteRequest, if sending is failed by recoverable error")
+	watchCmd.PersistentFlags().Duration(conf.OPT_RETRY_INTERVAL, conf.DEFAULT_RETRY_INTERVAL, "Wait before the first retry, doubled by each retry")
+	watchCmd.PersistentFlags().String(conf.OPT_WATCH_PATTERNS, conf.DEFAULT_WATCH_PATTERNS, "Comma-separated file name patterns of imported files")
+	watchCmd.PersistentFlags().Duration(conf.OPT_SETTLE_TIME, conf.DEFAULT_SETTLE_TIME, "A file is imported, if it's not changed for this time")
+	watchCmd.PersistentFlags().Duration(conf.OPT_POLL_INTERVAL, conf.DEFAULT_POLL_INTERVAL, "Interval of polling the directory")
+	watchCmd.PersistentFlags().String(conf.OPT_DONE_DIR, conf.DEFAULT_DONE_DIR, "Imported files are moved to this directory")
+	watchCmd.PersistentFlags().String(conf.OPT_FAILED_DIR, conf.DEFAULT_FAILED_DIR, "Failed files are moved to this directory")
+}
+
+// State of a file, which is not imported yet
+type spoolFile struct {
+	size    int64
+	modTime time.Time
+	changed time.Time
+}
+
+type spoolWatcher struct {
+	dir       string
+	doneDir   string
+	failedDir string
+	patterns  []string
+	settle    time.Duration
+	options   handler.ImportOptions
+	store     handler.StoreFunc
+	// By file name (without directory)
+	files map[string]*spoolFile
+}
+
+func startWatch(dir string) {
+	format, err := handler.FormatByName(viper.GetString(conf.OPT_INPUT_FORMAT))
+	if err != nil {
+		util.PrintFatalf("Input format error: %+v\n", err)
+	}
+	if err := handler.LoadRelabelConfigs(viper.GetString(conf.OPT_RELABEL_CONFIG_FILE)); err != nil {
+		util.PrintFatalf("Relabel config error: %+v\n", err)
+	}
+	options, err := handler.ServiceImportOptions(format)
+	if err != nil {
+		util.PrintFatalf("Options error: %+v\n", err)
+	}
+	store, err := handler.NewRemoteStore()
+	if err != nil {
+		util.PrintFatalf("Client error: %+v\n", err)
+	}
+
+	s := &spoolWatcher{
+		dir:       dir,
+		doneDir:   filepath.Join(dir, viper.GetString(conf.OPT_DONE_DIR)),
+		failedDir: filepath.Join(dir, viper.GetString(conf.OPT_FAILED_DIR)),
+		settle:    viper.GetDuration(conf.OPT_SETTLE_TIME),
+		options:   options,
+		store:     retryingStore(store, viper.GetInt(conf.OPT_RETRIES), viper.GetDuration(conf.OPT_RETRY_INTERVAL)),
+		files:     map[string]*spoolFile{},
+	}
+	for _, pattern := range strings.Split(viper.GetString(conf.OPT_WATCH_PATTERNS), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			s.patterns = append(s.patterns, pattern)
+		}
+	}
+	for _, subDir := range []string{s.doneDir, s.failedDir} {
+		if err := os.MkdirAll(subDir, 0755); err != nil {
+			util.PrintFatalf("Directory error: %+v\n", err)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		util.PrintFatalf("Watcher error: %+v\n", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		util.PrintFatalf("Watcher error: %+v\n", err)
+	}
+	glog.Infof("%s: watching %s\n", util.FUNCTION_NAME_SHORT(), dir)
+
+	pollTicker := time.NewTicker(viper.GetDuration(conf.OPT_POLL_INTERVAL))
+	defer pollTicker.Stop()
+	settleInterval := s.settle / 4
+	if settleInterval < 100*time.Millisecond {
+		settleInterval = 100 * time.Millisecond
+	}
+	settleTicker := time.NewTicker(settleInterval)
+	defer settleTicker.Stop()
+
+	s.scan()
+	for {
+		select {
+		case event := <-watcher.Events:
+			glog.V(2).Infof("%s: %s\n", util.FUNCTION_NAME_SHORT(), event)
+			if filepath.Dir(event.Name) != filepath.Clean(dir) {
+				continue
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				delete(s.files, filepath.Base(event.Name))
+			} else {
+				s.touch(filepath.Base(event.Name))
+			}
+		case err := <-watcher.Errors:
+			glog.Warningf("%s: Watcher error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		case <-pollTicker.C:
+			s.scan()
+		case <-settleTicker.C:
+			s.importSettled()
+		}
+	}
+}
+
+// Hidden files (for example temporary files before renaming) and directories are skipped
+func (s *spoolWatcher) matches(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+		return false
+	}
+	for _, pattern := range s.patterns {
+		if matched, _ := filepath.Match(pattern, info.Name()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// New files are added, existing files are checked for changes
+func (s *spoolWatcher) scan() {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		glog.Warningf("%s: Directory error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		return
+	}
+	for _, info := range infos {
+		if _, has := s.files[info.Name()]; !has && s.matches(info) {
+			s.files[info.Name()] = &spoolFile{size: info.Size(), modTime: info.ModTime(), changed: time.Now()}
+		}
+	}
+}
+
+func (s *spoolWatcher) touch(name string) {
+	info, err := os.Stat(filepath.Join(s.dir, name))
+	if err != nil || !s.matches(info) {
+		return
+	}
+	s.files[name] = &spoolFile{size: info.Size(), modTime: info.ModTime(), changed: time.Now()}
+}
+
+// Files not changed for the settle time are imported in the order of their names
+func (s *spoolWatcher) importSettled() {
+	settled := []string{}
+	for name, file := range s.files {
+		info, err := os.Stat(filepath.Join(s.dir, name))
+		if err != nil {
+			delete(s.files, name)
+			continue
+		}
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			file.size, file.modTime, file.changed = info.Size(), info.ModTime(), time.Now()
+			continue
+		}
+		if time.Since(file.changed) >= s.settle {
+			settled = append(settled, name)
+		}
+	}
+	sort.Strings(settled)
+
+	for _, name := range settled {
+		delete(s.files, name)
+		s.importFile(name)
+	}
+}
+
+// Recoverable errors are retried by the next poll
+func (s *spoolWatcher) importFile(name string) {
+	path := filepath.Join(s.dir, name)
+	options := s.options
+	options.Timestamps.ReceiveTimeMs = time.Now().UnixNano() / int64(time.Millisecond)
+	summary := handler.NewImportSummary()
+	err := func() error {
+		input, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+		return handler.Import(input, options, s.store, summary)
+	}()
+
+	if err == nil {
+		fmt.Printf("%s: %s\n", path, summary)
+		if err := os.Rename(path, filepath.Join(s.doneDir, name)); err != nil {
+			glog.Warningf("%s: Move error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		}
+		return
+	}
+	if remote.IsRecoverable(err) {
+		glog.Warningf("%s: %s is kept for retrying: %+v\n", util.FUNCTION_NAME_SHORT(), path, err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+	errorText := fmt.Sprintf("%s\n%s\n", err.Error(), summary)
+	if err := ioutil.WriteFile(filepath.Join(s.failedDir, name+ERROR_SUFFIX), []byte(errorText), 0644); err != nil {
+		glog.Warningf("%s: Sidecar error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+	}
+	if err := os.Rename(path, filepath.Join(s.failedDir, name)); err != nil {
+		glog.Warningf("%s: Move error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+	}
+}
@@ -71,6 +71,23 @@ func init() {
 	copystandardlogtoFlag.Hidden = true
 	viper.BindPFlag(conf.OPT_GLOG_COPYSTANDARDLOGTO, copystandardlogtoFlag)
 
+	RootCmd.PersistentFlags().String(conf.OPT_CONFIG, conf.DEFAULT_CONFIG, "Config file of CLI options (JSON, YAML, TOML, HCL or properties)")
+	viper.BindPFlag(conf.OPT_CONFIG, RootCmd.PersistentFlags().Lookup(conf.OPT_CONFIG))
+
+	// Used by the lint command and by the validate path of the service
+	RootCmd.PersistentFlags().Int(conf.OPT_LINT_MAX_SERIES, conf.DEFAULT_LINT_MAX_SERIES, "Lint: max count of series of a metric family (0: unlimited)")
+	viper.BindPFlag(conf.OPT_LINT_MAX_SERIES, RootCmd.PersistentFlags().Lookup(conf.OPT_LINT_MAX_SERIES))
+
+	RootCmd.PersistentFlags().Duration(conf.OPT_LINT_MAX_AGE, conf.DEFAULT_LINT_MAX_AGE, "Lint: max age of sample timestamps (0: unlimited)")
+	viper.BindPFlag(conf.OPT_LINT_MAX_AGE, RootCmd.PersistentFlags().Lookup(conf.OPT_LINT_MAX_AGE))
+
+	RootCmd.PersistentFlags().Duration(conf.OPT_LINT_MAX_FUTURE, conf.DEFAULT_LINT_MAX_FUTURE, "Lint: max future of sample timestamps (0: unlimited)")
+	viper.BindPFlag(conf.OPT_LINT_MAX_FUTURE, RootCmd.PersistentFlags().Lookup(conf.OPT_LINT_MAX_FUTURE))
+
+	// Used by the service and by the commands reading files
+	RootCmd.PersistentFlags().Int64(conf.OPT_MAX_DECOMPRESSED_SIZE, conf.DEFAULT_MAX_DECOMPRESSED_SIZE, "Max size of decompressed input in bytes (0: unlimited)")
+	viper.BindPFlag(conf.OPT_MAX_DECOMPRESSED_SIZE, RootCmd.PersistentFlags().Lookup(conf.OPT_MAX_DECOMPRESSED_SIZE))
+
 	cobra.OnInitialize()
 
 	goflag.CommandLine.Usage = func() {
@@ -83,6 +100,13 @@ func initConfig() {
 	viper.AutomaticEnv() // read in environment variables that match
 	viper.SetEnvKeyReplacer(getEnvReplacer())
 
+	if configFile := viper.GetString(conf.OPT_CONFIG); configFile != "" {
+		viper.SetConfigFile(configFile)
+		if err := viper.ReadInConfig(); err != nil {
+			util.PrintFatalf("Config file error: %+v\n", err)
+		}
+	}
+
 	// Apply if set
 	if copyStandardLogTo := viper.GetString("glog.copystandardlogto"); copyStandardLogTo != "" {
 		glog.CopyStandardLogTo(copyStandardLogTo)
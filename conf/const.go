@@ -1,14 +1,145 @@
 package conf
 
+import (
+	"time"
+)
+
 const (
 	OPT_RECEIVE_ON        = "receive-on"
 	OPT_RECEIVE_PATH_TEXT = "receive-path"
+	OPT_PUSHGATEWAY_PATH  = "pushgateway-path"
+	OPT_DRY_RUN_PATH      = "dry-run-path"
+	OPT_VALIDATE_PATH     = "validate-path"
 	OPT_WRITE_TO          = "write-to"
+	OPT_REMOTE_TIMEOUT    = "remote-timeout"
+	OPT_LENIENT           = "lenient"
+	OPT_SANITIZE          = "sanitize"
+	OPT_RETRY_AFTER       = "retry-after"
+
+	OPT_MAX_DECOMPRESSED_SIZE = "max-decompressed-size"
+	OPT_FLUSH_SAMPLES         = "flush-samples"
+	OPT_FLUSH_BYTES           = "flush-bytes"
+
+	OPT_MAX_SAMPLES_PER_REQUEST = "max-samples-per-request"
+	OPT_MAX_SERIES_PER_REQUEST  = "max-series-per-request"
+	OPT_MAX_BYTES_PER_REQUEST   = "max-bytes-per-request"
+
+	OPT_MISSING_TIMESTAMP = "missing-timestamp"
+	OPT_TIME_SHIFT        = "time-shift"
+	OPT_TIME_SHIFT_LABEL  = "time-shift-label"
+
+	OPT_DUPLICATE_TIMESTAMP = "duplicate-timestamp"
+	OPT_RELABEL_CONFIG_FILE = "relabel-config-file"
+
+	OPT_EXTERNAL_LABELS          = "external-labels"
+	OPT_EXTERNAL_LABELS_CONFLICT = "external-labels-conflict"
+
+	OPT_STALE_AFTER = "stale-after"
+
+	OPT_LINT_MAX_SERIES = "lint-max-series"
+	OPT_LINT_MAX_AGE    = "lint-max-age"
+	OPT_LINT_MAX_FUTURE = "lint-max-future"
+	OPT_INPUT_FORMAT    = "input-format"
+	OPT_OUTPUT_FORMAT   = "output-format"
+
+	OPT_INPUT           = "input"
+	OPT_OUTPUT          = "output"
+	OPT_OUTPUT_ENCODING = "output-encoding"
+	OPT_INPUT_ENCODING  = "input-encoding"
+
+	OPT_RETRIES           = "retries"
+	OPT_RETRY_INTERVAL    = "retry-interval"
+	OPT_PROGRESS_INTERVAL = "progress-interval"
+
+	OPT_TOP_LABELS = "top-labels"
+	OPT_PER_SERIES = "per-series"
+
+	OPT_WATCH_PATTERNS = "watch-patterns"
+	OPT_SETTLE_TIME    = "settle-time"
+	OPT_POLL_INTERVAL  = "poll-interval"
+	OPT_DONE_DIR       = "done-dir"
+	OPT_FAILED_DIR     = "failed-dir"
+	OPT_SPLIT          = "split"
+
+	OPT_CONFIG = "config"
 
 	OPT_COPYSTANDARDLOGTO      = "copystandardlogto"
 	OPT_GLOG_COPYSTANDARDLOGTO = "glog." + OPT_COPYSTANDARDLOGTO
 
 	DEFAULT_RECEIVE_ON        = ":9099"
 	DEFAULT_RECEIVE_PATH_TEXT = "/"
+	DEFAULT_PUSHGATEWAY_PATH  = "/metrics"
+	DEFAULT_DRY_RUN_PATH      = "/dry-run"
+	DEFAULT_VALIDATE_PATH     = "/validate"
 	DEFAULT_WRITE_TO          = "http://influxdb:8086/api/v1/prom/write?u=prom&p=prom&db=prometheus"
+	DEFAULT_REMOTE_TIMEOUT    = time.Second
+	DEFAULT_LENIENT           = false
+	DEFAULT_SANITIZE          = false
+	DEFAULT_RETRY_AFTER       = 30 * time.Second
+
+	DEFAULT_MAX_DECOMPRESSED_SIZE = 1 << 30
+	DEFAULT_FLUSH_SAMPLES         = 0
+	DEFAULT_FLUSH_BYTES           = 0
+
+	DEFAULT_MAX_SAMPLES_PER_REQUEST = 0
+	DEFAULT_MAX_SERIES_PER_REQUEST  = 0
+	DEFAULT_MAX_BYTES_PER_REQUEST   = 0
+
+	DEFAULT_MISSING_TIMESTAMP = "receive-time"
+	DEFAULT_TIME_SHIFT        = ""
+	DEFAULT_TIME_SHIFT_LABEL  = ""
+
+	DEFAULT_DUPLICATE_TIMESTAMP = "last"
+	DEFAULT_RELABEL_CONFIG_FILE = ""
+
+	DEFAULT_EXTERNAL_LABELS          = ""
+	DEFAULT_EXTERNAL_LABELS_CONFLICT = "keep"
+
+	DEFAULT_STALE_AFTER = time.Duration(0)
+
+	DEFAULT_LINT_MAX_SERIES = 10000
+	DEFAULT_LINT_MAX_AGE    = time.Hour
+	DEFAULT_LINT_MAX_FUTURE = 10 * time.Minute
+	DEFAULT_INPUT_FORMAT    = "text"
+	DEFAULT_OUTPUT_FORMAT   = "text"
+
+	DEFAULT_OUTPUT          = "-"
+	DEFAULT_OUTPUT_ENCODING = "snappy"
+	DEFAULT_INPUT_ENCODING  = "snappy"
+
+	DEFAULT_RETRIES           = 3
+	DEFAULT_RETRY_INTERVAL    = time.Second
+	DEFAULT_PROGRESS_INTERVAL = time.Second
+
+	DEFAULT_TOP_LABELS = 10
+	DEFAULT_PER_SERIES = false
+
+	DEFAULT_WATCH_PATTERNS = "*.prom,*.txt,*.gz,*.sz,*.snappy,*.zz,*.zst"
+	DEFAULT_SETTLE_TIME    = 2 * time.Second
+	DEFAULT_POLL_INTERVAL  = 10 * time.Second
+	DEFAULT_DONE_DIR       = "done"
+	DEFAULT_FAILED_DIR     = "failed"
+	DEFAULT_SPLIT          = "none"
+
+	DEFAULT_CONFIG = ""
+
+	PARAM_LENIENT           = "lenient"
+	PARAM_SANITIZE          = "sanitize"
+	PARAM_MISSING_TIMESTAMP = "missing_timestamp"
+	PARAM_BASE_TIMESTAMP    = "base_timestamp"
+	PARAM_TIME_SHIFT        = "time_shift"
+	PARAM_TIME_SHIFT_LABEL  = "time_shift_label"
+
+	PARAM_DUPLICATE_TIMESTAMP = "duplicate_timestamp"
+
+	PARAM_EXTRA_LABELS             = "extra_labels"
+	PARAM_EXTERNAL_LABELS_CONFLICT = "external_labels_conflict"
+
+	PARAM_STALE_AFTER = "stale_after"
+
+	PARAM_DRY_RUN = "dry_run"
+
+	PARAM_MAX_SERIES = "max_series"
+	PARAM_MAX_AGE    = "max_age"
+	PARAM_MAX_FUTURE = "max_future"
 )
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/golang/snappy"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// BatchLimits limits the size of a WriteRequest (0: no limit)
+type BatchLimits struct {
+	MaxSamples int
+	MaxSeries  int
+	// Size of the snappy-compressed protobuf
+	MaxBytes int
+}
+
+// SplitWriteRequest splits the request to more requests by the limits.
+// Samples of a series are split in their order, so sending the requests in order keeps the order of samples.
+func SplitWriteRequest(writeRequest *prompb.WriteRequest, limits BatchLimits) []*prompb.WriteRequest {
+	writeRequests := []*prompb.WriteRequest{}
+	batch := &prompb.WriteRequest{}
+	batchSamples := 0
+
+	for _, ts := range writeRequest.Timeseries {
+		samples := ts.Samples
+		for len(samples) > 0 {
+			if (limits.MaxSeries > 0 && len(batch.Timeseries) >= limits.MaxSeries) ||
+				(limits.MaxSamples > 0 && batchSamples >= limits.MaxSamples) {
+				writeRequests = append(writeRequests, batch)
+				batch = &prompb.WriteRequest{}
+				batchSamples = 0
+			}
+
+			count := len(samples)
+			if limits.MaxSamples > 0 && batchSamples+count > limits.MaxSamples {
+				count = limits.MaxSamples - batchSamples
+			}
+			batch.Timeseries = append(batch.Timeseries, &prompb.TimeSeries{
+				Labels:  ts.Labels,
+				Samples: samples[:count],
+			})
+			batchSamples += count
+			samples = samples[count:]
+		}
+	}
+	if len(batch.Timeseries) > 0 || len(writeRequests) == 0 {
+		writeRequests = append(writeRequests, batch)
+	}
+
+	if limits.MaxBytes <= 0 {
+		return writeRequests
+	}
+
+	limitedRequests := make([]*prompb.WriteRequest, 0, len(writeRequests))
+	for _, req := range writeRequests {
+		limitedRequests = append(limitedRequests, splitByBytes(req, limits.MaxBytes)...)
+	}
+	return limitedRequests
+}
+
+// MergeWriteRequests merges the series of the requests by labels, samples of a series are sorted by timestamp
+// and samples with the same timestamp are merged by the duplicates policy (see DUPLICATE_*).
+// The count of resolved duplicates is returned, rejected duplicates are reported by DuplicateTimestampError.
+func MergeWriteRequests(writeRequests []*prompb.WriteRequest, duplicates string) (*prompb.WriteRequest, int, error) {
+	labelsToSeries := map[string]*prompb.TimeSeries{}
+	for _, writeRequest := range writeRequests {
+		for _, ts := range writeRequest.Timeseries {
+			key := sortedLabelsKey(ts.Labels)
+			if series, has := labelsToSeries[key]; has {
+				series.Samples = append(series.Samples, ts.Samples...)
+				continue
+			}
+			labelsToSeries[key] = &prompb.TimeSeries{
+				Labels:  ts.Labels,
+				Samples: append([]*prompb.Sample{}, ts.Samples...),
+			}
+		}
+	}
+
+	merged := SeriesToWriteRequest(labelsToSeries)
+	resolved := 0
+	for _, ts := range merged.Timeseries {
+		count, err := resolveDuplicates(ts, duplicates)
+		if err != nil {
+			return nil, resolved, err
+		}
+		resolved += count
+	}
+	return merged, resolved, nil
+}
+
+// Same labels in different order have the same key
+func sortedLabelsKey(labels []*prompb.Label) string {
+	sorted := append([]*prompb.Label{}, labels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return labelsKey(sorted)
+}
+
+// Request is halved (by samples) until the compressed size fits to maxBytes
+func splitByBytes(writeRequest *prompb.WriteRequest, maxBytes int) []*prompb.WriteRequest {
+	if compressedSize(writeRequest) <= maxBytes {
+		return []*prompb.WriteRequest{writeRequest}
+	}
+
+	samples := 0
+	for _, ts := range writeRequest.Timeseries {
+		samples += len(ts.Samples)
+	}
+	if samples <= 1 {
+		glog.Warningf("%s: single sample is bigger than %d bytes\n", util.FUNCTION_NAME_SHORT(), maxBytes)
+		return []*prompb.WriteRequest{writeRequest}
+	}
+
+	first, second := &prompb.WriteRequest{}, &prompb.WriteRequest{}
+	half := samples / 2
+	for _, ts := range writeRequest.Timeseries {
+		switch {
+		case half <= 0:
+			second.Timeseries = append(second.Timeseries, ts)
+		case len(ts.Samples) <= half:
+			first.Timeseries = append(first.Timeseries, ts)
+		default:
+			first.Timeseries = append(first.Timeseries, &prompb.TimeSeries{Labels: ts.Labels, Samples: ts.Samples[:half]})
+			second.Timeseries = append(second.Timeseries, &prompb.TimeSeries{Labels: ts.Labels, Samples: ts.Samples[half:]})
+		}
+		half -= len(ts.Samples)
+	}
+
+	return append(splitByBytes(first, maxBytes), splitByBytes(second, maxBytes)...)
+}
+
+func compressedSize(writeRequest *prompb.WriteRequest) int {
+	data, err := writeRequest.Marshal()
+	if err != nil {
+		return 0
+	}
+	return len(snappy.Encode(nil, data))
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// testWriteRequest builds a WriteRequest having series with the given sample counts
+func testWriteRequest(sampleCounts ...int) *prompb.WriteRequest {
+	writeRequest := &prompb.WriteRequest{}
+	for s, count := range sampleCounts {
+		ts := &prompb.TimeSeries{Labels: []*prompb.Label{
+			{Name: "__name__", Value: "test_metric"},
+			{Name: "series", Value: fmt.Sprintf("%d", s)},
+		}}
+		for i := 0; i < count; i++ {
+			ts.Samples = append(ts.Samples, &prompb.Sample{Value: float64(i), Timestamp: int64(1000 * (i + 1))})
+		}
+		writeRequest.Timeseries = append(writeRequest.Timeseries, ts)
+	}
+	return writeRequest
+}
+
+// batchShape returns the sample counts of the series of each request
+func batchShape(writeRequests []*prompb.WriteRequest) [][]int {
+	shape := [][]int{}
+	for _, writeRequest := range writeRequests {
+		counts := []int{}
+		for _, ts := range writeRequest.Timeseries {
+			counts = append(counts, len(ts.Samples))
+		}
+		shape = append(shape, counts)
+	}
+	return shape
+}
+
+// Samples of each series must be kept in order across the requests
+func checkSampleOrder(t *testing.T, original *prompb.WriteRequest, writeRequests []*prompb.WriteRequest) {
+	timestamps := map[string][]int64{}
+	for _, writeRequest := range writeRequests {
+		for _, ts := range writeRequest.Timeseries {
+			key := labelsKey(ts.Labels)
+			for _, sample := range ts.Samples {
+				timestamps[key] = append(timestamps[key], sample.Timestamp)
+			}
+		}
+	}
+	for _, ts := range original.Timeseries {
+		expected := []int64{}
+		for _, sample := range ts.Samples {
+			expected = append(expected, sample.Timestamp)
+		}
+		if got := timestamps[labelsKey(ts.Labels)]; len(expected) > 0 && !reflect.DeepEqual(got, expected) {
+			t.Errorf("series %s: timestamps %v, expected %v", labelsKey(ts.Labels), got, expected)
+		}
+	}
+}
+
+func TestSplitWriteRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		series   []int
+		limits   BatchLimits
+		expected [][]int
+	}{
+		{"empty", []int{}, BatchLimits{MaxSamples: 2, MaxSeries: 2}, [][]int{{}}},
+		{"no limits", []int{3, 2, 1}, BatchLimits{}, [][]int{{3, 2, 1}}},
+		{"under limits", []int{1, 1}, BatchLimits{MaxSamples: 10, MaxSeries: 10}, [][]int{{1, 1}}},
+		{"samples", []int{2, 2, 2}, BatchLimits{MaxSamples: 3}, [][]int{{2, 1}, {1, 2}}},
+		{"samples of a series", []int{5}, BatchLimits{MaxSamples: 2}, [][]int{{2}, {2}, {1}}},
+		{"samples exactly", []int{2, 2}, BatchLimits{MaxSamples: 2}, [][]int{{2}, {2}}},
+		{"series", []int{1, 2, 3}, BatchLimits{MaxSeries: 2}, [][]int{{1, 2}, {3}}},
+		{"single series", []int{1, 1, 1}, BatchLimits{MaxSeries: 1}, [][]int{{1}, {1}, {1}}},
+		{"samples and series", []int{1, 4, 1}, BatchLimits{MaxSamples: 3, MaxSeries: 2}, [][]int{{1, 2}, {2, 1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeRequest := testWriteRequest(test.series...)
+			writeRequests := SplitWriteRequest(writeRequest, test.limits)
+			if shape := batchShape(writeRequests); !reflect.DeepEqual(shape, test.expected) {
+				t.Errorf("shape %v, expected %v", shape, test.expected)
+			}
+			checkSampleOrder(t, writeRequest, writeRequests)
+		})
+	}
+}
+
+func TestSplitWriteRequestBytes(t *testing.T) {
+	singleSample := compressedSize(testWriteRequest(1))
+	tests := []struct {
+		name     string
+		series   []int
+		maxBytes int
+		requests int
+	}{
+		{"fits", []int{4, 4}, compressedSize(testWriteRequest(4, 4)), 1},
+		{"halved", []int{4, 4}, compressedSize(testWriteRequest(4, 4)) - 1, 2},
+		{"single samples", []int{2, 2}, singleSample, 4},
+		{"single sample over limit", []int{3}, 1, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeRequest := testWriteRequest(test.series...)
+			writeRequests := SplitWriteRequest(writeRequest, BatchLimits{MaxBytes: test.maxBytes})
+			if len(writeRequests) != test.requests {
+				t.Errorf("%d requests %v, expected %d", len(writeRequests), batchShape(writeRequests), test.requests)
+			}
+
+			samples := 0
+			for _, req := range writeRequests {
+				if size := compressedSize(req); size > test.maxBytes && requestSamples(req) > 1 {
+					t.Errorf("request of %d samples is %d bytes, over %d", requestSamples(req), size, test.maxBytes)
+				}
+				samples += requestSamples(req)
+			}
+			if samples != requestSamples(writeRequest) {
+				t.Errorf("%d samples, expected %d", samples, requestSamples(writeRequest))
+			}
+			checkSampleOrder(t, writeRequest, writeRequests)
+		})
+	}
+}
+
+func requestSamples(writeRequest *prompb.WriteRequest) int {
+	samples := 0
+	for _, ts := range writeRequest.Timeseries {
+		samples += len(ts.Samples)
+	}
+	return samples
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/snappy"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/remote"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Media type of the snappy-compressed protobuf WriteRequest (as sent by remote_write)
+const ProtobufType = "application/x-protobuf"
+
+// Import summary of a dry run is sent in this header (see ImportSummary.String)
+const HEADER_IMPORT_SUMMARY = "X-Import-Summary"
+
+// HandleDryRun runs the whole import of HandlePush, but the WriteRequests are responded instead of sending
+func HandleDryRun(w http.ResponseWriter, req *http.Request) {
+	glog.V(1).Infof("%s: %s %s\n", util.FUNCTION_NAME_SHORT(), req.Method, req.URL.String())
+	switch req.Method {
+	case "PUT", "POST":
+		importRequest(w, req, nil, true)
+	}
+}
+
+// CollectingStore returns a StoreFunc collecting the WriteRequests instead of sending
+func CollectingStore(writeRequests *[]*prompb.WriteRequest) StoreFunc {
+	return func(writeRequest *prompb.WriteRequest) error {
+		*writeRequests = append(*writeRequests, writeRequest)
+		return nil
+	}
+}
+
+// WriteRequests are written as JSON (same to util.LogObjAsJson), or as snappy-compressed protobuf,
+// if the client prefers application/x-protobuf (see Accept header).
+// More WriteRequests are written as JSON array or as multipart/mixed protobuf parts.
+func writeDryRun(w http.ResponseWriter, req *http.Request, writeRequests []*prompb.WriteRequest, summary *ImportSummary) {
+	w.Header().Set(HEADER_IMPORT_SUMMARY, summary.String())
+
+	if prefersProtobuf(req) {
+		if err := writeProtobufs(w, writeRequests); err != nil {
+			glog.Warningf("%s: Protobuf error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	jsonRequests := make([]remote.JSONWriteRequest, 0, len(writeRequests))
+	for _, writeRequest := range writeRequests {
+		jsonRequests = append(jsonRequests, remote.NewJSONWriteRequest(writeRequest))
+	}
+	var obj interface{} = jsonRequests
+	if len(jsonRequests) == 1 {
+		obj = jsonRequests[0]
+	}
+	objJson, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		glog.Warningf("%s: JSON error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(objJson)
+	w.Write([]byte("\n"))
+}
+
+func writeProtobufs(w http.ResponseWriter, writeRequests []*prompb.WriteRequest) error {
+	if len(writeRequests) == 1 {
+		data, err := EncodeWriteRequest(writeRequests[0])
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", ProtobufType)
+		w.Header().Set("Content-Disposition", `attachment; filename="write_request.pb.snappy"`)
+		w.Write(data)
+		return nil
+	}
+
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	for r, writeRequest := range writeRequests {
+		data, err := EncodeWriteRequest(writeRequest)
+		if err != nil {
+			return err
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", ProtobufType)
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="write_request_%d.pb.snappy"`, r+1))
+		part, err := parts.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		part.Write(data)
+	}
+	return parts.Close()
+}
+
+// EncodeWriteRequest returns the snappy-compressed protobuf of the WriteRequest
+func EncodeWriteRequest(writeRequest *prompb.WriteRequest) ([]byte, error) {
+	data, err := writeRequest.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// The first known media type of Accept header is chosen, JSON is the default
+func prefersProtobuf(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case ProtobufType, "application/octet-stream":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
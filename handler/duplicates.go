@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Policies for samples of a series with the same timestamp
+const (
+	DUPLICATE_FIRST  = "first"
+	DUPLICATE_LAST   = "last"
+	DUPLICATE_MAX    = "max"
+	DUPLICATE_MIN    = "min"
+	DUPLICATE_AVG    = "avg"
+	DUPLICATE_REJECT = "reject"
+)
+
+// DuplicateTimestampError is returned by Import, if the duplicate policy is DUPLICATE_REJECT
+type DuplicateTimestampError struct {
+	Labels      []*prompb.Label
+	TimestampMs int64
+}
+
+func (e DuplicateTimestampError) Error() string {
+	labels := make([]string, 0, len(e.Labels))
+	for _, label := range e.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	return fmt.Sprintf("duplicate timestamp %d with different values: {%s}", e.TimestampMs, strings.Join(labels, ", "))
+}
+
+// IsDuplicatePolicy is true, if the policy is known
+func IsDuplicatePolicy(policy string) bool {
+	switch policy {
+	case DUPLICATE_FIRST, DUPLICATE_LAST, DUPLICATE_MAX, DUPLICATE_MIN, DUPLICATE_AVG, DUPLICATE_REJECT:
+		return true
+	}
+	return false
+}
+
+// Duplicates and unordered samples are resolved in a flush only.
+// Samples not newer than the last sample of the series, sent by an earlier flush, are counted.
+func (imp *importer) countCrossFlushSamples(writeRequest *prompb.WriteRequest) {
+	if len(imp.lastSamples) == 0 {
+		return
+	}
+	for _, ts := range writeRequest.Timeseries {
+		last, has := imp.lastSamples[labelsKey(ts.Labels)]
+		if !has {
+			continue
+		}
+		for _, sample := range ts.Samples {
+			if sample.Timestamp == last.timestampMs {
+				imp.summary.CrossFlushDuplicates++
+			} else if sample.Timestamp < last.timestampMs {
+				imp.summary.CrossFlushOutOfOrder++
+			}
+		}
+	}
+}
+
+// Samples of the series must be ordered by timestamp (duplicates in input order).
+// Duplicates are merged by the policy (empty is DUPLICATE_LAST), the count of removed samples is returned.
+// Duplicates with the same value are merged by DUPLICATE_REJECT, too.
+func resolveDuplicates(ts *prompb.TimeSeries, policy string) (int, error) {
+	if len(ts.Samples) < 2 {
+		return 0, nil
+	}
+
+	samples := ts.Samples[:1]
+	count := 1
+	for _, sample := range ts.Samples[1:] {
+		last := samples[len(samples)-1]
+		if sample.Timestamp != last.Timestamp {
+			samples = append(samples, sample)
+			count = 1
+			continue
+		}
+
+		switch policy {
+		case DUPLICATE_FIRST:
+		case DUPLICATE_MAX:
+			last.Value = math.Max(last.Value, sample.Value)
+		case DUPLICATE_MIN:
+			last.Value = math.Min(last.Value, sample.Value)
+		case DUPLICATE_AVG:
+			// Running average
+			last.Value += (sample.Value - last.Value) / float64(count+1)
+		case DUPLICATE_REJECT:
+			if sample.Value != last.Value && !(math.IsNaN(sample.Value) && math.IsNaN(last.Value)) {
+				return 0, DuplicateTimestampError{Labels: ts.Labels, TimestampMs: sample.Timestamp}
+			}
+		default:
+			last.Value = sample.Value
+		}
+		count++
+	}
+
+	resolved := len(ts.Samples) - len(samples)
+	ts.Samples = samples
+	return resolved, nil
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content encodings
+const (
+	ENCODING_IDENTITY = "identity"
+	ENCODING_GZIP     = "gzip"
+	ENCODING_DEFLATE  = "deflate"
+	ENCODING_SNAPPY   = "snappy"
+	ENCODING_ZSTD     = "zstd"
+)
+
+var ErrTooLarge = errors.New("decompressed size is over the limit")
+
+// UnsupportedEncodingError is returned if the encoding cannot be decompressed
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+func (e UnsupportedEncodingError) Error() string {
+	return "unsupported encoding: " + e.Encoding
+}
+
+// encodingErrorStatus returns 415 for an unsupported encoding and 400 for a corrupt body
+func encodingErrorStatus(err error) int {
+	if _, unsupported := err.(UnsupportedEncodingError); unsupported {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// Magic bytes of compressed files
+var (
+	magicGzip         = []byte{0x1f, 0x8b}
+	magicSnappyFramed = []byte("\xff\x06\x00\x00sNaPpY")
+	magicZstd         = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// EncodedReader returns a reader decompressing by the Content-Encoding header value.
+// More encodings (applied in the listed order) are decompressed in reverse order.
+// If maxSize is positive and the data is decompressed, the reader returns ErrTooLarge if the decompressed data is bigger.
+func EncodedReader(r io.Reader, contentEncoding string, maxSize int64) (io.Reader, error) {
+	decoded := false
+	encodings := strings.Split(contentEncoding, ",")
+	for e := len(encodings) - 1; e >= 0; e-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[e]))
+		var err error
+		if r, err = decodingReader(r, encoding); err != nil {
+			return nil, err
+		}
+		decoded = decoded || !isIdentity(encoding)
+	}
+
+	if !decoded {
+		return r, nil
+	}
+	return limitedReader(r, maxSize), nil
+}
+
+// CompressedReader returns a reader decompressing by the detected file format (see magic bytes).
+// If maxSize is positive and the file is compressed, the reader returns ErrTooLarge if the decompressed data is bigger.
+func CompressedReader(r io.Reader, maxSize int64) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(magicSnappyFramed))
+
+	encoding := ENCODING_IDENTITY
+	switch {
+	case bytes.HasPrefix(header, magicGzip):
+		encoding = ENCODING_GZIP
+	case bytes.HasPrefix(header, magicSnappyFramed):
+		encoding = ENCODING_SNAPPY
+	case bytes.HasPrefix(header, magicZstd):
+		encoding = ENCODING_ZSTD
+	case len(header) >= 2 && isZlibHeader(header[0], header[1]):
+		encoding = ENCODING_DEFLATE
+	}
+
+	if isIdentity(encoding) {
+		return br, nil
+	}
+	decoded, err := decodingReader(br, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return limitedReader(decoded, maxSize), nil
+}
+
+func isIdentity(encoding string) bool {
+	return encoding == "" || encoding == ENCODING_IDENTITY
+}
+
+func decodingReader(r io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "", ENCODING_IDENTITY:
+		return r, nil
+	case ENCODING_GZIP, "x-gzip":
+		return gzip.NewReader(r)
+	case ENCODING_DEFLATE:
+		// HTTP deflate is zlib format, but some clients send raw deflate
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header[0], header[1]) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case ENCODING_SNAPPY, "x-snappy-framed":
+		return snappy.NewReader(r), nil
+	case ENCODING_ZSTD, "x-zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{decoder: decoder}, nil
+	default:
+		return nil, UnsupportedEncodingError{Encoding: encoding}
+	}
+}
+
+// zstdReader releases the resources of the decoder at the end of the stream
+type zstdReader struct {
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.decoder.Read(p)
+	if err != nil {
+		z.err = err
+		z.decoder.Close()
+	}
+	return n, err
+}
+
+// See RFC 1950: CM = 8 (deflate) and the header checksum
+func isZlibHeader(cmf byte, flg byte) bool {
+	return cmf&0x0f == 8 && (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
+
+func limitedReader(r io.Reader, maxSize int64) io.Reader {
+	if maxSize <= 0 {
+		return r
+	}
+	return &maxSizeReader{r: r, n: maxSize}
+}
+
+// maxSizeReader returns ErrTooLarge, if more than n bytes are read
+type maxSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Policies for external labels, which are already on the series
+const (
+	EXTERNAL_LABELS_KEEP     = "keep"
+	EXTERNAL_LABELS_OVERRIDE = "override"
+	EXTERNAL_LABELS_REJECT   = "reject"
+)
+
+// External labels of a request can be set by this header
+const HEADER_EXTRA_LABELS = "X-Extra-Labels"
+
+// ExternalLabels are added to every series
+type ExternalLabels struct {
+	Labels map[string]string
+	// EXTERNAL_LABELS_KEEP, EXTERNAL_LABELS_OVERRIDE or EXTERNAL_LABELS_REJECT
+	Conflict string
+}
+
+// ExternalLabelConflictError is returned by Import, if the conflict policy is EXTERNAL_LABELS_REJECT
+type ExternalLabelConflictError struct {
+	Name          string
+	Value         string
+	ExternalValue string
+}
+
+func (e ExternalLabelConflictError) Error() string {
+	return fmt.Sprintf("external label %s=%q conflicts with the label of the series: %s=%q",
+		e.Name, e.ExternalValue, e.Name, e.Value,
+	)
+}
+
+// IsExternalLabelsConflict is true, if the policy is known
+func IsExternalLabelsConflict(policy string) bool {
+	switch policy {
+	case EXTERNAL_LABELS_KEEP, EXTERNAL_LABELS_OVERRIDE, EXTERNAL_LABELS_REJECT:
+		return true
+	}
+	return false
+}
+
+// ParseLabels parses comma-separated name=value pairs, for example "env=prod,cluster=eu-1".
+// Parsed labels are added to labels (existing names are overridden).
+func ParseLabels(value string, labels map[string]string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		nameValue := strings.SplitN(pair, "=", 2)
+		if len(nameValue) != 2 {
+			return fmt.Errorf("expected name=value, got %q", pair)
+		}
+		name := strings.TrimSpace(nameValue[0])
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid label name: %q", name)
+		}
+		labels[name] = strings.TrimSpace(nameValue[1])
+	}
+	return nil
+}
+
+// Labels are set on every metric by the conflict policy
+func (e ExternalLabels) apply(metricFamilies map[string]*dto.MetricFamily) error {
+	if len(e.Labels) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.Labels))
+	for name := range e.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, m := range metricFamilies {
+		for _, s := range m.GetMetric() {
+			for _, name := range names {
+				value, has := labelValue(s.GetLabel(), name)
+				switch {
+				case !has:
+				case e.Conflict == EXTERNAL_LABELS_KEEP:
+					continue
+				case e.Conflict == EXTERNAL_LABELS_REJECT && value != e.Labels[name]:
+					return ExternalLabelConflictError{Name: name, Value: value, ExternalValue: e.Labels[name]}
+				}
+				s.Label = setLabelPair(s.Label, name, e.Labels[name])
+			}
+		}
+	}
+	return nil
+}
+
+func labelValue(labels []*dto.LabelPair, name string) (string, bool) {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return label.GetValue(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// RequestFormat extracts the input format from Content-Type header.
+// Text exposition format is the default.
+func RequestFormat(h http.Header) expfmt.Format {
+	mediatype, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err == nil && mediatype == OpenMetricsType {
+		return FmtOpenMetrics
+	}
+	if expfmt.ResponseFormat(h) == expfmt.FmtProtoDelim {
+		return expfmt.FmtProtoDelim
+	}
+	return expfmt.FmtText
+}
+
+// Input format names of CLI commands
+const (
+	FORMAT_TEXT        = "text"
+	FORMAT_OPENMETRICS = "openmetrics"
+	FORMAT_PROTOBUF    = "protobuf"
+)
+
+// FormatByName returns the input format of the name (see FORMAT_*)
+func FormatByName(name string) (expfmt.Format, error) {
+	switch name {
+	case FORMAT_TEXT:
+		return expfmt.FmtText, nil
+	case FORMAT_OPENMETRICS:
+		return FmtOpenMetrics, nil
+	case FORMAT_PROTOBUF:
+		return expfmt.FmtProtoDelim, nil
+	}
+	return expfmt.FmtUnknown, fmt.Errorf("unknown input format: %q", name)
+}
@@ -0,0 +1,397 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/prometheus/common/expfmt"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/relabel"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Input is parsed in chunks of this size (chunks are split at line or message boundaries)
+const importChunkSize = 1 << 20
+
+// Histogram and summary families are not split, except the chunk is much bigger than importChunkSize
+const importChunkSizeMax = 16 * importChunkSize
+
+// ImportOptions configures an import
+type ImportOptions struct {
+	Format  expfmt.Format
+	Lenient bool
+	// Invalid metric and label names are repaired before parsing
+	Sanitize bool
+	// Series are sent, if the count of merged samples reaches FlushSamples (0: no limit).
+	// Duplicates are merged and samples are sorted within a flush only, samples not newer than the
+	// last sample of the series sent by an earlier flush are counted by ImportSummary.CrossFlush*.
+	FlushSamples int
+	// Series are sent, if the size of parsed input reaches FlushBytes (0: no limit)
+	FlushBytes int64
+	// Sent series are split to more WriteRequests by Limits
+	Limits BatchLimits
+	// Timestamps of samples without timestamp
+	Timestamps TimestampPolicy
+	// Samples are shifted before sending (if TimeShift.Newest, the flush budget is not used, so the whole input is buffered)
+	TimeShift TimeShift
+	// Policy for samples of a series with the same timestamp (see DUPLICATE_*, empty is DUPLICATE_LAST)
+	Duplicates string
+	// Labels added to every series (overriding the labels of the input)
+	GroupingLabels map[string]string
+	// Series are relabeled before sending
+	RelabelConfigs []*relabel.Config
+	// Labels added to every series (after GroupingLabels)
+	ExternalLabels ExternalLabels
+	// If it's positive, a staleness marker is sent to each series, StaleAfter later than its last sample
+	StaleAfter time.Duration
+	// If it's set, it's called with the parsed metric families, before any change
+	Inspect func(metricFamilies map[string]*dto.MetricFamily)
+}
+
+// TextErrors is returned by Import, if the input is invalid (and not lenient)
+type TextErrors []TextError
+
+func (e TextErrors) Error() string {
+	return TextErrorsToString(e)
+}
+
+// ReadError is returned by Import, if the input cannot be read
+type ReadError struct {
+	error
+}
+
+// StoreFunc sends a WriteRequest
+type StoreFunc func(writeRequest *prompb.WriteRequest) error
+
+// Import reads the input in chunks, merges the parsed samples to series and sends them by store,
+// if the flush budget is reached and at the end of the input, so memory usage does not depend on the input size.
+// Invalid input is reported by TextErrors (series sent before finding the error are not revoked).
+// Rejected duplicates are reported by DuplicateTimestampError, rejected external labels by ExternalLabelConflictError.
+func Import(r io.Reader, options ImportOptions, store StoreFunc, summary *ImportSummary) error {
+	if options.Duplicates != "" && !IsDuplicatePolicy(options.Duplicates) {
+		return fmt.Errorf("invalid duplicate timestamp policy: %q", options.Duplicates)
+	}
+
+	imp := &importer{
+		options:        options,
+		store:          store,
+		summary:        summary,
+		labelsToSeries: map[string]*prompb.TimeSeries{},
+		lastSamples:    map[string]lastSample{},
+	}
+
+	var err error
+	switch options.Format {
+	case expfmt.FmtProtoDelim:
+		err = imp.readMessages(r)
+	default:
+		err = imp.readLines(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := imp.flush(); err != nil {
+		return err
+	}
+	return imp.sendStaleMarkers()
+}
+
+type importer struct {
+	options ImportOptions
+	store   StoreFunc
+	summary *ImportSummary
+
+	openMetricsParser OpenMetricsParser
+
+	labelsToSeries map[string]*prompb.TimeSeries
+	pendingSamples int
+	pendingBytes   int64
+
+	lastSamples map[string]lastSample
+}
+
+// Lines are collected to chunks, histogram and summary families are not split
+func (imp *importer) readLines(r io.Reader) error {
+	br := bufio.NewReader(r)
+	chunk := []byte{}
+	chunkFirstLine := 1
+	lineCount := 0
+	family, familyType := "", ""
+
+	for {
+		line, readErr := br.ReadBytes('\n')
+		if len(line) > 0 {
+			lineCount++
+			name, lineType, isMeta := lineFamily(line)
+			sameFamily := family != "" && (name == family || strings.HasPrefix(name, family+"_"))
+			cannotSplit := sameFamily && (isMeta || isAggregatedType(familyType))
+			if len(chunk) >= importChunkSizeMax || (len(chunk) >= importChunkSize && !cannotSplit) {
+				if err := imp.processChunk(chunk, chunkFirstLine); err != nil {
+					return err
+				}
+				chunk = []byte{}
+				chunkFirstLine = lineCount
+			}
+
+			if isMeta && name != family {
+				family, familyType = name, ""
+			}
+			if lineType != "" {
+				familyType = lineType
+			}
+			chunk = append(chunk, line...)
+		}
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return ReadError{readErr}
+		}
+	}
+
+	if err := imp.processChunk(chunk, chunkFirstLine); err != nil {
+		return err
+	}
+
+	if imp.options.Format == FmtOpenMetrics {
+		if textErrors := imp.openMetricsParser.Finish(lineCount + 1); len(textErrors) > 0 {
+			if err := imp.addErrors(textErrors, 0); err != nil {
+				return err
+			}
+		}
+		imp.summary.addDropped(DROP_UNSUPPORTED_TYPE, imp.openMetricsParser.Created)
+	}
+
+	return nil
+}
+
+// Returns the metric family name, the type (of TYPE line) and true, if it's a HELP or TYPE line
+func lineFamily(line []byte) (string, string, bool) {
+	if bytes.HasPrefix(line, []byte("# ")) {
+		parts := strings.Fields(string(line))
+		if len(parts) >= 3 && (parts[1] == "HELP" || parts[1] == "TYPE") {
+			if parts[1] == "TYPE" && len(parts) >= 4 {
+				return parts[2], parts[3], true
+			}
+			return parts[2], "", true
+		}
+		return "", "", false
+	}
+
+	end := bytes.IndexAny(line, "{ \t\n")
+	if end < 0 {
+		end = len(line)
+	}
+	return string(line[:end]), "", false
+}
+
+// Samples of these types are merged by the text parser
+func isAggregatedType(familyType string) bool {
+	switch familyType {
+	case "histogram", "summary", "gaugehistogram":
+		return true
+	}
+	return false
+}
+
+// Length-delimited messages are collected to chunks
+func (imp *importer) readMessages(r io.Reader) error {
+	br := bufio.NewReader(r)
+	chunk := []byte{}
+	chunkFirstMessage := 1
+	messageCount := 0
+
+	for {
+		messageLength, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		messageCount++
+		if err == io.ErrUnexpectedEOF {
+			if err := imp.addErrors([]TextError{{Line: messageCount, Msg: "unexpected end of input stream"}}, 0); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return ReadError{err}
+		}
+		if messageLength > importChunkSizeMax {
+			if err := imp.addErrors([]TextError{{Line: messageCount, Msg: "too large message"}}, 0); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(ioutil.Discard, br, int64(messageLength)); err != nil {
+				break
+			}
+			continue
+		}
+
+		if len(chunk) >= importChunkSize {
+			if err := imp.processChunk(chunk, chunkFirstMessage); err != nil {
+				return err
+			}
+			chunk = []byte{}
+			chunkFirstMessage = messageCount
+		}
+
+		message := make([]byte, messageLength)
+		if _, err := io.ReadFull(br, message); err == io.ErrUnexpectedEOF || err == io.EOF {
+			if err := imp.addErrors([]TextError{{Line: messageCount, Msg: "unexpected end of input stream"}}, 0); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return ReadError{err}
+		}
+		var header [binary.MaxVarintLen64]byte
+		chunk = append(chunk, header[:binary.PutUvarint(header[:], messageLength)]...)
+		chunk = append(chunk, message...)
+	}
+
+	return imp.processChunk(chunk, chunkFirstMessage)
+}
+
+// Chunk is parsed, merged and flushed, if the budget is reached
+func (imp *importer) processChunk(chunk []byte, firstLine int) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	if imp.options.Sanitize {
+		if imp.options.Format == expfmt.FmtProtoDelim {
+			chunk = sanitizeProtobuf(chunk, imp.summary)
+		} else {
+			chunk = sanitizeText(chunk, imp.summary)
+		}
+	}
+
+	var metricFamiliesList []map[string]*dto.MetricFamily
+	var textErrors []TextError
+	var missingLines []int
+	switch imp.options.Format {
+	case FmtOpenMetrics:
+		metricFamiliesList, textErrors = imp.openMetricsParser.Parse(chunk, imp.options.Lenient)
+		missingLines = missingTimestampLines(imp.openMetricsParser.text, textErrors)
+	case expfmt.FmtProtoDelim:
+		metricFamiliesList, textErrors = ParseProtobuf(chunk, imp.options.Lenient)
+	default:
+		metricFamiliesList, textErrors = ParseText(chunk, imp.options.Lenient)
+		missingLines = missingTimestampLines(chunk, textErrors)
+	}
+	if err := imp.addErrors(textErrors, firstLine-1); err != nil {
+		return err
+	}
+	imp.summary.addMissingTimestampLines(imp.options.Timestamps.outcome(), missingLines, firstLine-1)
+
+	util.LogObjAsJson(2, metricFamiliesList, "metricFamiliesList", true)
+	for _, metricFamilies := range metricFamiliesList {
+		if imp.options.Inspect != nil {
+			imp.options.Inspect(metricFamilies)
+		}
+		setLabels(metricFamilies, imp.options.GroupingLabels)
+		if err := imp.options.ExternalLabels.apply(metricFamilies); err != nil {
+			return err
+		}
+		imp.options.Timestamps.apply(metricFamilies, imp.summary)
+		mergeMetrics(imp.labelsToSeries, metricFamilies, imp.summary)
+	}
+	imp.pendingSamples = countSamples(imp.labelsToSeries)
+	imp.pendingBytes += int64(len(chunk))
+
+	if imp.options.TimeShift.Newest {
+		// Newest sample is known at the end of the input
+		return nil
+	}
+	if (imp.options.FlushSamples > 0 && imp.pendingSamples >= imp.options.FlushSamples) ||
+		(imp.options.FlushBytes > 0 && imp.pendingBytes >= imp.options.FlushBytes) {
+		return imp.flush()
+	}
+	return nil
+}
+
+// Line numbers of errors are shifted by lineOffset.
+// In lenient mode, errors are collected to the summary, otherwise TextErrors is returned.
+func (imp *importer) addErrors(textErrors []TextError, lineOffset int) error {
+	if len(textErrors) == 0 {
+		return nil
+	}
+	for e := range textErrors {
+		textErrors[e].Line += lineOffset
+	}
+	if !imp.options.Lenient {
+		return TextErrors(textErrors)
+	}
+	imp.summary.Errors = append(imp.summary.Errors, textErrors...)
+	imp.summary.addDropped(DROP_INVALID, len(textErrors))
+	return nil
+}
+
+// Merged series are sent
+func (imp *importer) flush() error {
+	if len(imp.labelsToSeries) == 0 {
+		return nil
+	}
+
+	imp.labelsToSeries = relabelSeries(imp.labelsToSeries, imp.options.RelabelConfigs, imp.summary)
+	if len(imp.labelsToSeries) == 0 {
+		imp.pendingSamples = 0
+		imp.pendingBytes = 0
+		return nil
+	}
+
+	if imp.options.TimeShift.Enabled() {
+		imp.summary.TimeShiftMs = imp.options.TimeShift.apply(imp.labelsToSeries)
+	}
+
+	writeRequest := SeriesToWriteRequest(imp.labelsToSeries)
+	for _, ts := range writeRequest.Timeseries {
+		resolved, err := resolveDuplicates(ts, imp.options.Duplicates)
+		if err != nil {
+			return err
+		}
+		imp.summary.DuplicatesResolved += resolved
+	}
+	imp.countCrossFlushSamples(writeRequest)
+
+	writeRequests := SplitWriteRequest(writeRequest, imp.options.Limits)
+	glog.V(1).Infof("%s: sending %d series, %d samples in %d requests\n", util.FUNCTION_NAME_SHORT(),
+		len(imp.labelsToSeries), imp.pendingSamples, len(writeRequests),
+	)
+
+	for _, writeRequest := range writeRequests {
+		util.LogObjAsJson(2, writeRequest, "writeRequest", true)
+		start := time.Now()
+		if err := imp.store(writeRequest); err != nil {
+			return err
+		}
+		imp.summary.addSent(writeRequest, time.Since(start))
+	}
+	imp.trackLastSamples(writeRequest)
+	for key := range imp.labelsToSeries {
+		imp.summary.addSeries(key)
+	}
+
+	imp.labelsToSeries = map[string]*prompb.TimeSeries{}
+	imp.pendingSamples = 0
+	imp.pendingBytes = 0
+	return nil
+}
+
+func countSamples(labelsToSeries map[string]*prompb.TimeSeries) int {
+	count := 0
+	for _, ts := range labelsToSeries {
+		count += len(ts.Samples)
+	}
+	return count
+}
@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"io"
+	"sort"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/remote"
+)
+
+// Inspector collects statistics of the input, in the order of the samples (before merging and sorting).
+// A histogram or a summary of the text format is counted as one sample.
+type Inspector struct {
+	families    map[string]*inspectedFamily
+	series      map[string]*inspectedSeries
+	seriesTexts []string
+	labelValues map[string]map[string]bool
+}
+
+type inspectedFamily struct {
+	metricType string
+	series     int
+}
+
+type inspectedSeries struct {
+	family            string
+	timestamps        []int64
+	withoutTimestamp  int
+	outOfOrderSamples int
+}
+
+// NewInspector returns an empty Inspector
+func NewInspector() *Inspector {
+	return &Inspector{
+		families:    map[string]*inspectedFamily{},
+		series:      map[string]*inspectedSeries{},
+		labelValues: map[string]map[string]bool{},
+	}
+}
+
+// InspectMetrics parses the input (text, OpenMetrics or protobuf) to the inspector
+func InspectMetrics(r io.Reader, format expfmt.Format, inspector *Inspector) error {
+	// Duplicates are counted by the inspector, so they are not rejected by the import
+	options := ImportOptions{
+		Format:     format,
+		Duplicates: DUPLICATE_LAST,
+		Inspect:    inspector.AddMetricFamilies,
+	}
+	discard := func(writeRequest *prompb.WriteRequest) error {
+		return nil
+	}
+	return Import(r, options, discard, NewImportSummary())
+}
+
+// AddMetricFamilies adds the parsed metric families
+func (i *Inspector) AddMetricFamilies(metricFamilies map[string]*dto.MetricFamily) {
+	for name, m := range metricFamilies {
+		metricType := m.GetType().String()
+		for _, s := range m.GetMetric() {
+			labels := make([]*prompb.Label, 0, len(s.GetLabel())+1)
+			labels = append(labels, &prompb.Label{Name: model.MetricNameLabel, Value: name})
+			for _, label := range s.GetLabel() {
+				labels = append(labels, &prompb.Label{Name: label.GetName(), Value: label.GetValue()})
+			}
+			series := i.addSeries(name, metricType, labels)
+			if s.TimestampMs == nil {
+				series.withoutTimestamp++
+				continue
+			}
+			series.addTimestamp(s.GetTimestampMs())
+		}
+	}
+}
+
+// AddWriteRequest adds the series of a WriteRequest, metric types are unknown
+func (i *Inspector) AddWriteRequest(writeRequest *prompb.WriteRequest) {
+	for _, ts := range writeRequest.Timeseries {
+		name := ""
+		for _, label := range ts.Labels {
+			if label.Name == model.MetricNameLabel {
+				name = label.Value
+			}
+		}
+		series := i.addSeries(name, "", ts.Labels)
+		for _, sample := range ts.Samples {
+			series.addTimestamp(sample.Timestamp)
+		}
+	}
+}
+
+func (i *Inspector) addSeries(name string, metricType string, labels []*prompb.Label) *inspectedSeries {
+	family, has := i.families[name]
+	if !has {
+		family = &inspectedFamily{metricType: metricType}
+		i.families[name] = family
+	}
+
+	seriesText := remote.SeriesText(labels)
+	series, has := i.series[seriesText]
+	if !has {
+		series = &inspectedSeries{family: name}
+		i.series[seriesText] = series
+		i.seriesTexts = append(i.seriesTexts, seriesText)
+		family.series++
+
+		for _, label := range labels {
+			if label.Name == model.MetricNameLabel {
+				continue
+			}
+			values, has := i.labelValues[label.Name]
+			if !has {
+				values = map[string]bool{}
+				i.labelValues[label.Name] = values
+			}
+			values[label.Value] = true
+		}
+	}
+	return series
+}
+
+func (s *inspectedSeries) addTimestamp(timestampMs int64) {
+	if n := len(s.timestamps); n > 0 && timestampMs < s.timestamps[n-1] {
+		s.outOfOrderSamples++
+	}
+	s.timestamps = append(s.timestamps, timestampMs)
+}
+
+// InspectReport is the result of Inspector, time ranges and intervals are in ms (0: no timestamped sample)
+type InspectReport struct {
+	Families                int                `json:"families"`
+	Series                  int                `json:"series"`
+	Samples                 int                `json:"samples"`
+	SamplesWithoutTimestamp int                `json:"samples_without_timestamp"`
+	MinTimestampMs          int64              `json:"min_timestamp_ms"`
+	MaxTimestampMs          int64              `json:"max_timestamp_ms"`
+	DuplicateTimestamps     int                `json:"duplicate_timestamps"`
+	OutOfOrderSamples       int                `json:"out_of_order_samples"`
+	Metrics                 []MetricReport     `json:"metrics"`
+	Labels                  []LabelCardinality `json:"labels"`
+	SeriesDetails           []SeriesReport     `json:"series_details,omitempty"`
+}
+
+// InspectStats are the counters of a metric or a series
+type InspectStats struct {
+	Samples                 int   `json:"samples"`
+	SamplesWithoutTimestamp int   `json:"samples_without_timestamp"`
+	MinTimestampMs          int64 `json:"min_timestamp_ms"`
+	MaxTimestampMs          int64 `json:"max_timestamp_ms"`
+	// Median of the differences of the ordered, distinct timestamps
+	TypicalIntervalMs   int64 `json:"typical_interval_ms"`
+	DuplicateTimestamps int   `json:"duplicate_timestamps"`
+	OutOfOrderSamples   int   `json:"out_of_order_samples"`
+}
+
+// MetricReport is the statistics of a metric family
+type MetricReport struct {
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Series int    `json:"series"`
+	InspectStats
+}
+
+// SeriesReport is the statistics of a series
+type SeriesReport struct {
+	Series string `json:"series"`
+	InspectStats
+}
+
+// LabelCardinality is the count of distinct values of a label name
+type LabelCardinality struct {
+	Name   string `json:"name"`
+	Values int    `json:"values"`
+}
+
+// Report returns the statistics, ordered by metric name, labels are ordered by cardinality (top labels are listed).
+// Statistics of series are listed, if perSeries is true.
+func (i *Inspector) Report(topLabels int, perSeries bool) InspectReport {
+	report := InspectReport{
+		Families: len(i.families),
+		Series:   len(i.series),
+		Metrics:  []MetricReport{},
+		Labels:   []LabelCardinality{},
+	}
+
+	familyStats := map[string]*InspectStats{}
+	familyIntervals := map[string][]int64{}
+	for _, seriesText := range i.seriesTexts {
+		series := i.series[seriesText]
+		stats, intervals := series.stats()
+		if perSeries {
+			report.SeriesDetails = append(report.SeriesDetails, SeriesReport{Series: seriesText, InspectStats: stats})
+		}
+
+		if _, has := familyStats[series.family]; !has {
+			familyStats[series.family] = &InspectStats{}
+		}
+		addStats(familyStats[series.family], stats)
+		familyIntervals[series.family] = append(familyIntervals[series.family], intervals...)
+	}
+
+	total := &InspectStats{}
+	for name, family := range i.families {
+		stats := familyStats[name]
+		stats.TypicalIntervalMs = medianMs(familyIntervals[name])
+		addStats(total, *stats)
+		report.Metrics = append(report.Metrics, MetricReport{
+			Name:         name,
+			Type:         family.metricType,
+			Series:       family.series,
+			InspectStats: *stats,
+		})
+	}
+	sort.Slice(report.Metrics, func(a, b int) bool {
+		return report.Metrics[a].Name < report.Metrics[b].Name
+	})
+	report.Samples = total.Samples
+	report.SamplesWithoutTimestamp = total.SamplesWithoutTimestamp
+	report.MinTimestampMs = total.MinTimestampMs
+	report.MaxTimestampMs = total.MaxTimestampMs
+	report.DuplicateTimestamps = total.DuplicateTimestamps
+	report.OutOfOrderSamples = total.OutOfOrderSamples
+
+	for name, values := range i.labelValues {
+		report.Labels = append(report.Labels, LabelCardinality{Name: name, Values: len(values)})
+	}
+	sort.Slice(report.Labels, func(a, b int) bool {
+		if report.Labels[a].Values != report.Labels[b].Values {
+			return report.Labels[a].Values > report.Labels[b].Values
+		}
+		return report.Labels[a].Name < report.Labels[b].Name
+	})
+	if topLabels > 0 && len(report.Labels) > topLabels {
+		report.Labels = report.Labels[:topLabels]
+	}
+
+	return report
+}
+
+// Intervals are the differences of the ordered, distinct timestamps
+func (s *inspectedSeries) stats() (InspectStats, []int64) {
+	stats := InspectStats{
+		Samples:                 len(s.timestamps) + s.withoutTimestamp,
+		SamplesWithoutTimestamp: s.withoutTimestamp,
+		OutOfOrderSamples:       s.outOfOrderSamples,
+	}
+	// Samples repeated without timestamp would get the same timestamp
+	if s.withoutTimestamp > 1 {
+		stats.DuplicateTimestamps = s.withoutTimestamp - 1
+	}
+	if len(s.timestamps) == 0 {
+		return stats, nil
+	}
+
+	timestamps := make([]int64, len(s.timestamps))
+	copy(timestamps, s.timestamps)
+	sort.Slice(timestamps, func(a, b int) bool {
+		return timestamps[a] < timestamps[b]
+	})
+	stats.MinTimestampMs = timestamps[0]
+	stats.MaxTimestampMs = timestamps[len(timestamps)-1]
+
+	intervals := make([]int64, 0, len(timestamps)-1)
+	for t := 1; t < len(timestamps); t++ {
+		if timestamps[t] == timestamps[t-1] {
+			stats.DuplicateTimestamps++
+			continue
+		}
+		intervals = append(intervals, timestamps[t]-timestamps[t-1])
+	}
+	stats.TypicalIntervalMs = medianMs(intervals)
+	return stats, intervals
+}
+
+// Time ranges are merged, TypicalIntervalMs is not changed
+func addStats(total *InspectStats, stats InspectStats) {
+	if stats.Samples > stats.SamplesWithoutTimestamp {
+		if total.Samples == total.SamplesWithoutTimestamp || stats.MinTimestampMs < total.MinTimestampMs {
+			total.MinTimestampMs = stats.MinTimestampMs
+		}
+		if total.Samples == total.SamplesWithoutTimestamp || stats.MaxTimestampMs > total.MaxTimestampMs {
+			total.MaxTimestampMs = stats.MaxTimestampMs
+		}
+	}
+	total.Samples += stats.Samples
+	total.SamplesWithoutTimestamp += stats.SamplesWithoutTimestamp
+	total.DuplicateTimestamps += stats.DuplicateTimestamps
+	total.OutOfOrderSamples += stats.OutOfOrderSamples
+}
+
+func medianMs(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a] < sorted[b]
+	})
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/spf13/viper"
+
+	"github.com/prometheus/common/expfmt"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/conf"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Lint checks, similar to promlint (github.com/prometheus/client_golang/prometheus/testutil/promlint)
+const (
+	LINT_PARSE_ERROR      = "parse_error"
+	LINT_NO_HELP          = "no_help"
+	LINT_NO_TYPE          = "no_type"
+	LINT_COUNTER_TOTAL    = "counter_total"
+	LINT_NON_BASE_UNIT    = "non_base_unit"
+	LINT_MIXED_TIMESTAMPS = "mixed_timestamps"
+	LINT_CARDINALITY      = "cardinality"
+	LINT_TIMESTAMP_WINDOW = "timestamp_window"
+	LINT_UNSUPPORTED_TYPE = "unsupported_type"
+
+	LINT_DUPLICATE_TIMESTAMP = "duplicate_timestamp"
+)
+
+// Non-base units and abbreviations in metric names, see https://prometheus.io/docs/practices/naming/#base-units
+var lintNonBaseUnits = map[string]string{
+	"milliseconds": "seconds", "microseconds": "seconds", "nanoseconds": "seconds",
+	"minutes": "seconds", "hours": "seconds", "days": "seconds", "weeks": "seconds",
+	"ms": "seconds", "us": "seconds", "ns": "seconds", "sec": "seconds", "secs": "seconds",
+	"kilobytes": "bytes", "megabytes": "bytes", "gigabytes": "bytes", "terabytes": "bytes",
+	"kibibytes": "bytes", "mebibytes": "bytes", "gibibytes": "bytes", "tebibytes": "bytes",
+	"kb": "bytes", "mb": "bytes", "gb": "bytes", "tb": "bytes", "bits": "bytes",
+	"millimeters": "meters", "centimeters": "meters", "kilometers": "meters",
+	"milligrams": "grams", "kilograms": "grams",
+	"millivolts": "volts", "milliamperes": "amperes", "kilojoules": "joules",
+	"fahrenheit": "celsius", "percent": "ratio",
+}
+
+// LintOptions configures the checks
+type LintOptions struct {
+	Format expfmt.Format
+	// Count of series of a metric family, over which a cardinality problem is reported (0: disabled)
+	MaxSeries int
+	// Samples older than MaxAge or newer than MaxFuture (relative to now) are reported (0: disabled)
+	MaxAge    time.Duration
+	MaxFuture time.Duration
+}
+
+// LintProblem is a finding of Lint
+type LintProblem struct {
+	// Line (or protobuf message) number of parse errors
+	Line   int    `json:"line,omitempty"`
+	Metric string `json:"metric,omitempty"`
+	Check  string `json:"check"`
+	Text   string `json:"text"`
+}
+
+func (p LintProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s (%s)", p.Line, p.Text, p.Check)
+	}
+	return fmt.Sprintf("%s: %s (%s)", p.Metric, p.Text, p.Check)
+}
+
+// Lint checks the input without sending, problems are ordered by line and metric name
+func Lint(r io.Reader, options LintOptions) ([]LintProblem, error) {
+	linter := &linter{
+		options:  options,
+		families: map[string]*lintFamily{},
+		nowMs:    time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	summary := NewImportSummary()
+	// Duplicates are reported by the linter, so they are not rejected by the import
+	importOptions := ImportOptions{
+		Format:     options.Format,
+		Lenient:    true,
+		Duplicates: DUPLICATE_LAST,
+		Inspect:    linter.inspect,
+	}
+	discard := func(writeRequest *prompb.WriteRequest) error {
+		return nil
+	}
+	if err := Import(r, importOptions, discard, summary); err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, textError := range summary.Errors {
+		problems = append(problems, LintProblem{
+			Line:  textError.Line,
+			Check: LINT_PARSE_ERROR,
+			Text:  textError.Msg + ": " + fmt.Sprintf("%q", textError.Text),
+		})
+	}
+	problems = append(problems, linter.problems()...)
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Metric < problems[j].Metric
+	})
+	return problems, nil
+}
+
+// Metric family state, collected from all chunks
+type lintFamily struct {
+	hasHelp       bool
+	metricType    dto.MetricType
+	timestamped   int
+	untimestamped int
+	seriesKeys    map[string]bool
+	outOfWindow   int
+	oldestMs      int64
+	newestMs      int64
+	// Timestamps of the series, see duplicated and repeated
+	seriesTimestamps map[string]map[int64]bool
+	seriesRepeated   map[string]bool
+	// Count of samples with the same labels and timestamp
+	duplicated int
+	// Count of samples with the same labels, repeated without timestamp
+	repeated int
+}
+
+type linter struct {
+	options  LintOptions
+	families map[string]*lintFamily
+	names    []string
+	nowMs    int64
+}
+
+func (l *linter) inspect(metricFamilies map[string]*dto.MetricFamily) {
+	minMs := l.nowMs - int64(l.options.MaxAge/time.Millisecond)
+	maxMs := l.nowMs + int64(l.options.MaxFuture/time.Millisecond)
+
+	for name, m := range metricFamilies {
+		family, has := l.families[name]
+		if !has {
+			family = &lintFamily{
+				metricType:       m.GetType(),
+				seriesKeys:       map[string]bool{},
+				seriesTimestamps: map[string]map[int64]bool{},
+				seriesRepeated:   map[string]bool{},
+			}
+			l.families[name] = family
+			l.names = append(l.names, name)
+		}
+		family.hasHelp = family.hasHelp || m.Help != nil
+
+		for _, s := range m.GetMetric() {
+			key := concatLabels(name, s.GetLabel())
+			if l.options.MaxSeries <= 0 || len(family.seriesKeys) <= l.options.MaxSeries {
+				family.seriesKeys[key] = true
+			}
+			if s.TimestampMs == nil {
+				family.untimestamped++
+				if family.seriesRepeated[key] {
+					family.repeated++
+				}
+				family.seriesRepeated[key] = true
+				continue
+			}
+			family.timestamped++
+			timestampMs := s.GetTimestampMs()
+			timestamps := family.seriesTimestamps[key]
+			if timestamps == nil {
+				timestamps = map[int64]bool{}
+				family.seriesTimestamps[key] = timestamps
+			}
+			if timestamps[timestampMs] {
+				family.duplicated++
+			}
+			timestamps[timestampMs] = true
+			if (l.options.MaxAge > 0 && timestampMs < minMs) || (l.options.MaxFuture > 0 && timestampMs > maxMs) {
+				if family.outOfWindow == 0 || timestampMs < family.oldestMs {
+					family.oldestMs = timestampMs
+				}
+				if family.outOfWindow == 0 || timestampMs > family.newestMs {
+					family.newestMs = timestampMs
+				}
+				family.outOfWindow++
+			}
+		}
+	}
+}
+
+func (l *linter) problems() []LintProblem {
+	problems := []LintProblem{}
+	add := func(name string, check string, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{Metric: name, Check: check, Text: fmt.Sprintf(format, args...)})
+	}
+
+	for _, name := range l.names {
+		family := l.families[name]
+		if !family.hasHelp {
+			add(name, LINT_NO_HELP, "no help text")
+		}
+		switch family.metricType {
+		case dto.MetricType_UNTYPED:
+			add(name, LINT_NO_TYPE, "no type")
+		case dto.MetricType_COUNTER:
+			if !strings.HasSuffix(name, "_total") {
+				add(name, LINT_COUNTER_TOTAL, `counter metrics should have "_total" suffix`)
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_SUMMARY, dto.MetricType_HISTOGRAM:
+		default:
+			add(name, LINT_UNSUPPORTED_TYPE, "unsupported metric type %s", family.metricType)
+		}
+		for _, token := range strings.Split(strings.ToLower(name), "_") {
+			if base, has := lintNonBaseUnits[token]; has {
+				add(name, LINT_NON_BASE_UNIT, "use base unit %q instead of %q", base, token)
+			}
+		}
+		if family.timestamped > 0 && family.untimestamped > 0 {
+			add(name, LINT_MIXED_TIMESTAMPS, "%d samples with and %d samples without timestamp",
+				family.timestamped, family.untimestamped,
+			)
+		}
+		if family.duplicated > 0 {
+			add(name, LINT_DUPLICATE_TIMESTAMP, "%d samples with the same labels and timestamp", family.duplicated)
+		}
+		if family.repeated > 0 {
+			add(name, LINT_DUPLICATE_TIMESTAMP, "%d samples with the same labels without timestamp", family.repeated)
+		}
+		if l.options.MaxSeries > 0 && len(family.seriesKeys) > l.options.MaxSeries {
+			add(name, LINT_CARDINALITY, "more than %d series", l.options.MaxSeries)
+		}
+		if family.outOfWindow > 0 {
+			add(name, LINT_TIMESTAMP_WINDOW, "%d samples outside the accepted window (now -%s, now +%s): [%s, %s]",
+				family.outOfWindow, l.options.MaxAge, l.options.MaxFuture,
+				FormatTimestampMs(family.oldestMs), FormatTimestampMs(family.newestMs),
+			)
+		}
+	}
+	return problems
+}
+
+// Options of the service are overridden by the query parameters
+func requestLintOptions(req *http.Request) (LintOptions, error) {
+	options := LintOptions{Format: RequestFormat(req.Header)}
+	var err error
+	if options.MaxSeries, err = intParam(req, conf.PARAM_MAX_SERIES, viper.GetInt(conf.OPT_LINT_MAX_SERIES)); err != nil {
+		return options, err
+	}
+	if options.MaxAge, err = durationParam(req, conf.PARAM_MAX_AGE, viper.GetDuration(conf.OPT_LINT_MAX_AGE)); err != nil {
+		return options, err
+	}
+	if options.MaxFuture, err = durationParam(req, conf.PARAM_MAX_FUTURE, viper.GetDuration(conf.OPT_LINT_MAX_FUTURE)); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// LintProblemsToString lists the problems, one problem per line
+func LintProblemsToString(problems []LintProblem) string {
+	lines := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		lines = append(lines, problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HandleValidate lints the request body without sending, the problems are responded
+// as JSON, or as text if the client prefers text/plain (see Accept header)
+func HandleValidate(w http.ResponseWriter, req *http.Request) {
+	glog.V(1).Infof("%s: %s %s\n", util.FUNCTION_NAME_SHORT(), req.Method, req.URL.String())
+	switch req.Method {
+	case "PUT", "POST":
+	default:
+		return
+	}
+
+	reader, err := EncodedReader(req.Body, req.Header.Get("Content-Encoding"), viper.GetInt64(conf.OPT_MAX_DECOMPRESSED_SIZE))
+	if err != nil {
+		http.Error(w, err.Error(), encodingErrorStatus(err))
+		return
+	}
+	options, err := requestLintOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	problems, err := Lint(reader, options)
+	if err != nil {
+		status := http.StatusBadRequest
+		if readErr, ok := err.(ReadError); ok && readErr.error == ErrTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if prefersText(req) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if len(problems) > 0 {
+			fmt.Fprintln(w, LintProblemsToString(problems))
+		}
+		return
+	}
+	problemsJson, err := json.Marshal(struct {
+		Problems []LintProblem `json:"problems"`
+	}{problems})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(problemsJson)
+	w.Write([]byte("\n"))
+}
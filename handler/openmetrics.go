@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/prometheus/common/expfmt"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// OpenMetrics text format, see https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+const (
+	OpenMetricsType                  = "application/openmetrics-text"
+	FmtOpenMetrics     expfmt.Format = OpenMetricsType + "; version=1.0.0; charset=utf-8"
+	openMetricsEOF                   = "# EOF"
+	openMetricsTotal                 = "_total"
+	openMetricsCreated               = "_created"
+)
+
+// OpenMetricsParser parses the OpenMetrics text format, the input can be split to chunks at line boundaries.
+// The chunk is translated to the text exposition format line by line (so line numbers are kept), and parsed by ParseText:
+//   - "# UNIT" lines are skipped
+//   - counter family names get the "_total" suffix
+//   - "unknown" type is mapped to "untyped", "info", "stateset" and "gaugehistogram" samples are untyped
+//   - "_created" samples of counters, histograms and summaries are skipped (counted in Created)
+//   - exemplars are skipped
+//   - timestamps are converted from seconds to milliseconds
+//   - input must be terminated by "# EOF" (see Finish)
+type OpenMetricsParser struct {
+	Created int
+
+	family     string
+	familyType string
+	eof        bool
+	// Translated text of the last chunk
+	text []byte
+}
+
+// Parse parses a chunk, line numbers of errors are relative to the chunk
+func (p *OpenMetricsParser) Parse(data []byte, lenient bool) ([]map[string]*dto.MetricFamily, []TextError) {
+	lines := strings.Split(string(data), "\n")
+	text, textErrors := p.toText(lines)
+	p.text = text
+
+	metricFamiliesList, parseErrors := ParseText(text, lenient)
+	for e := range parseErrors {
+		if parseErrors[e].Line <= len(lines) {
+			parseErrors[e].Text = lines[parseErrors[e].Line-1]
+		}
+	}
+	textErrors = append(textErrors, parseErrors...)
+	sort.SliceStable(textErrors, func(i, j int) bool {
+		return textErrors[i].Line < textErrors[j].Line
+	})
+
+	if len(textErrors) > 0 && !lenient {
+		return nil, textErrors[:1]
+	}
+	return metricFamiliesList, textErrors
+}
+
+// Finish checks the end of the input, lastLine is the number of lines
+func (p *OpenMetricsParser) Finish(lastLine int) []TextError {
+	if p.eof {
+		return nil
+	}
+	textError := TextError{Line: lastLine, Msg: "missing " + openMetricsEOF}
+	glog.Warningf("%s: %s\n", util.FUNCTION_NAME_SHORT(), textError.Error())
+	return []TextError{textError}
+}
+
+// Invalid lines are translated to empty lines
+func (p *OpenMetricsParser) toText(lines []string) ([]byte, []TextError) {
+	out := make([]string, len(lines))
+	textErrors := []TextError{}
+
+	addError := func(l int, msg string) {
+		textError := TextError{Line: l + 1, Text: lines[l], Msg: msg}
+		glog.Warningf("%s: %s\n", util.FUNCTION_NAME_SHORT(), textError.Error())
+		textErrors = append(textErrors, textError)
+	}
+
+	helpLine := -1
+	for l, line := range lines {
+		if p.eof {
+			if line != "" {
+				addError(l, "unexpected content after "+openMetricsEOF)
+				break
+			}
+			continue
+		}
+
+		if line == openMetricsEOF {
+			p.eof = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "# ") {
+			parts := strings.SplitN(line, " ", 4)
+			if len(parts) < 3 {
+				out[l] = line
+				continue
+			}
+			keyword, name, rest := parts[1], parts[2], ""
+			if len(parts) == 4 {
+				rest = parts[3]
+			}
+			if name != p.family {
+				p.family, p.familyType, helpLine = name, "", -1
+			}
+
+			switch keyword {
+			case "TYPE":
+				p.familyType = rest
+				switch p.familyType {
+				case "counter":
+					out[l] = "# TYPE " + openMetricsCounterName(name) + " counter"
+					if helpLine >= 0 {
+						out[helpLine] = openMetricsHelpLine(openMetricsCounterName(name), lines[helpLine])
+					}
+				case "gauge", "histogram", "summary":
+					out[l] = line
+				case "unknown":
+					out[l] = "# TYPE " + name + " untyped"
+				case "info", "stateset", "gaugehistogram":
+					// Samples are untyped
+				default:
+					addError(l, "unknown metric type "+strconv.Quote(p.familyType))
+				}
+			case "HELP":
+				helpLine = l
+				if p.familyType == "counter" {
+					out[l] = openMetricsHelpLine(openMetricsCounterName(name), line)
+				} else {
+					out[l] = line
+				}
+			case "UNIT":
+				// Skipped
+			default:
+				out[l] = line
+			}
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+
+		name, labels, rest, err := splitSampleLine(line)
+		if err != nil {
+			addError(l, err.Error())
+			continue
+		}
+		if name == p.family+openMetricsCreated {
+			switch p.familyType {
+			case "counter", "histogram", "summary":
+				p.Created++
+				continue
+			}
+		}
+
+		// Exemplar is skipped
+		if i := strings.Index(rest, " # "); i >= 0 {
+			rest = rest[:i]
+		}
+		fields := strings.Fields(rest)
+		switch len(fields) {
+		case 1:
+			out[l] = name + labels + " " + fields[0]
+		case 2:
+			timestampMs, err := secondsToMs(fields[1])
+			if err != nil {
+				addError(l, "expected number as timestamp, got "+strconv.Quote(fields[1]))
+				continue
+			}
+			out[l] = name + labels + " " + fields[0] + " " + strconv.FormatInt(timestampMs, 10)
+		default:
+			addError(l, "expected value and optional timestamp, got "+strconv.Quote(rest))
+		}
+	}
+
+	return []byte(strings.Join(out, "\n")), textErrors
+}
+
+func openMetricsCounterName(name string) string {
+	if strings.HasSuffix(name, openMetricsTotal) {
+		return name
+	}
+	return name + openMetricsTotal
+}
+
+func openMetricsHelpLine(name string, line string) string {
+	parts := strings.SplitN(line, " ", 4)
+	help := ""
+	if len(parts) == 4 {
+		help = " " + parts[3]
+	}
+	return "# HELP " + name + help
+}
+
+func secondsToMs(seconds string) (int64, error) {
+	f, err := strconv.ParseFloat(seconds, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("invalid timestamp")
+	}
+	return int64(math.Round(f * 1000)), nil
+}
+
+// Splits the sample line to metric name, label set (including braces) and the rest
+func splitSampleLine(line string) (string, string, string, error) {
+	i := strings.IndexAny(line, "{ \t")
+	if i < 0 {
+		return "", "", "", errors.New("expected value after metric name")
+	}
+	name := line[:i]
+	if line[i] != '{' {
+		return name, "", line[i:], nil
+	}
+
+	inQuote, escaped := false, false
+	for j := i + 1; j < len(line); j++ {
+		c := line[j]
+		switch {
+		case escaped:
+			escaped = false
+		case inQuote && c == '\\':
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+		case c == '}' && !inQuote:
+			return name, line[i : j+1], line[j+1:], nil
+		}
+	}
+	return "", "", "", errors.New("unterminated label set")
+}
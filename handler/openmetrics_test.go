@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenMetricsToText(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		text    string
+		errors  []int
+		created int
+		eof     bool
+	}{
+		{
+			name:  "gauge with timestamp",
+			input: "# TYPE g gauge\ng{a=\"1\"} 2 1.5\n# EOF",
+			text:  "# TYPE g gauge\ng{a=\"1\"} 2 1500\n",
+			eof:   true,
+		},
+		{
+			name:  "counter",
+			input: "# HELP c Requests.\n# TYPE c counter\nc_total 3\n# EOF",
+			text:  "# HELP c_total Requests.\n# TYPE c_total counter\nc_total 3\n",
+			eof:   true,
+		},
+		{
+			name:  "counter having total suffix",
+			input: "# TYPE c_total counter\nc_total 3\n",
+			text:  "# TYPE c_total counter\nc_total 3\n",
+		},
+		{
+			name:    "created",
+			input:   "# TYPE c counter\nc_total 3\nc_created 1000\n# TYPE h histogram\nh_count 1\nh_created 1000\n# EOF",
+			text:    "# TYPE c_total counter\nc_total 3\n\n# TYPE h histogram\nh_count 1\n\n",
+			created: 2,
+			eof:     true,
+		},
+		{
+			name:  "created of gauge",
+			input: "# TYPE g gauge\ng_created 1000\n",
+			text:  "# TYPE g gauge\ng_created 1000\n",
+		},
+		{
+			name:  "unit",
+			input: "# TYPE d gauge\n# UNIT d seconds\nd 1\n",
+			text:  "# TYPE d gauge\n\nd 1\n",
+		},
+		{
+			name:  "unknown and info types",
+			input: "# TYPE u unknown\nu 1\n# TYPE i info\ni_info{v=\"1\"} 1\n",
+			text:  "# TYPE u untyped\nu 1\n\ni_info{v=\"1\"} 1\n",
+		},
+		{
+			name:  "exemplar",
+			input: "# TYPE h histogram\nh_bucket{le=\"+Inf\"} 2 # {trace_id=\"abc\"} 0.5 1.1\nh_count 2 2 # {trace_id=\"abc\"} 0.5\n",
+			text:  "# TYPE h histogram\nh_bucket{le=\"+Inf\"} 2\nh_count 2 2000\n",
+		},
+		{
+			name:   "invalid type and timestamp",
+			input:  "# TYPE x foo\nx 1\ng 1 abc\n",
+			text:   "\nx 1\n\n",
+			errors: []int{1, 3},
+		},
+		{
+			name:   "content after EOF",
+			input:  "g 1\n# EOF\ng 2\n",
+			text:   "g 1\n\n\n",
+			errors: []int{3},
+			eof:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var parser OpenMetricsParser
+			text, textErrors := parser.toText(strings.Split(test.input, "\n"))
+			if string(text) != test.text {
+				t.Errorf("text %q, expected %q", text, test.text)
+			}
+			if lines := errorLines(textErrors); !reflect.DeepEqual(lines, append([]int{}, test.errors...)) {
+				t.Errorf("error lines %v, expected %v", lines, test.errors)
+			}
+			if parser.Created != test.created {
+				t.Errorf("created %d, expected %d", parser.Created, test.created)
+			}
+			if parser.eof != test.eof {
+				t.Errorf("EOF %v, expected %v", parser.eof, test.eof)
+			}
+		})
+	}
+}
+
+func TestOpenMetricsParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		families   []string
+		errors     []int
+		missingEOF bool
+	}{
+		{"single chunk", []string{"# TYPE c counter\nc_total 1 1\n# EOF\n"}, []string{"c_total"}, nil, false},
+		{"more chunks", []string{"# TYPE c counter\nc_total 1 1\n", "# TYPE g gauge\ng 1\n# EOF\n"}, []string{"c_total", "g"}, nil, false},
+		{"missing EOF", []string{"# TYPE g gauge\ng 1\n"}, []string{"g"}, nil, true},
+		{"invalid value", []string{"g x\ng 1\n# EOF\n"}, []string{"g"}, []int{1}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var parser OpenMetricsParser
+			families := []string{}
+			textErrors := []TextError{}
+			for _, chunk := range test.chunks {
+				metricFamiliesList, chunkErrors := parser.Parse([]byte(chunk), true)
+				for _, metricFamilies := range metricFamiliesList {
+					for name := range metricFamilies {
+						families = append(families, name)
+					}
+				}
+				textErrors = append(textErrors, chunkErrors...)
+			}
+			if !reflect.DeepEqual(families, test.families) {
+				t.Errorf("families %v, expected %v", families, test.families)
+			}
+			if lines := errorLines(textErrors); !reflect.DeepEqual(lines, append([]int{}, test.errors...)) {
+				t.Errorf("error lines %v, expected %v: %s", lines, test.errors, TextErrorsToString(textErrors))
+			}
+			if missingEOF := len(parser.Finish(10)) > 0; missingEOF != test.missingEOF {
+				t.Errorf("missing EOF %v, expected %v", missingEOF, test.missingEOF)
+			}
+		})
+	}
+}
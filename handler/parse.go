@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// TextError is a parse error, extended with the failing line
+type TextError struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	Msg  string `json:"msg"`
+}
+
+func (e TextError) Error() string {
+	return fmt.Sprintf("line %d: %s: %q", e.Line, e.Msg, e.Text)
+}
+
+// TextErrorsToString lists the errors, one error per line
+func TextErrorsToString(textErrors []TextError) string {
+	lines := make([]string, 0, len(textErrors))
+	for _, e := range textErrors {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseText parses the text exposition format.
+// If lenient is false, parsing is stopped at the first error.
+// If lenient is true, the failing line is skipped and parsing is continued from the next line,
+// so more metric family maps can be returned (same metric family can be in more maps).
+func ParseText(data []byte, lenient bool) ([]map[string]*dto.MetricFamily, []TextError) {
+	metricFamiliesList := []map[string]*dto.MetricFamily{}
+	textErrors := []TextError{}
+
+	// Missing newline at the end of the last line is tolerated
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+
+	for _, group := range splitTimestampGroups(data) {
+		groupFamiliesList, groupErrors := parseTextGroup(group.data, lenient)
+		metricFamiliesList = append(metricFamiliesList, groupFamiliesList...)
+		for _, textError := range groupErrors {
+			textError.Line = group.originalLine(textError.Line)
+			textErrors = append(textErrors, textError)
+		}
+		if !lenient && len(textErrors) > 0 {
+			return nil, textErrors
+		}
+	}
+	sort.SliceStable(textErrors, func(i, j int) bool { return textErrors[i].Line < textErrors[j].Line })
+
+	return metricFamiliesList, textErrors
+}
+
+func parseTextGroup(data []byte, lenient bool) ([]map[string]*dto.MetricFamily, []TextError) {
+	metricFamiliesList := []map[string]*dto.MetricFamily{}
+	textErrors := []TextError{}
+
+	firstLine := 1
+	for len(data) > 0 {
+		var parser expfmt.TextParser
+		metricFamilies, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+		if err == nil {
+			metricFamiliesList = append(metricFamiliesList, metricFamilies)
+			break
+		}
+
+		parseErr, ok := err.(expfmt.ParseError)
+		if !ok {
+			parseErr = expfmt.ParseError{Line: 1, Msg: err.Error()}
+		}
+		valid, text, rest := splitAtLine(data, parseErr.Line)
+		textError := TextError{
+			Line: firstLine + parseErr.Line - 1,
+			Text: text,
+			Msg:  parseErr.Msg,
+		}
+		glog.Warningf("%s: %s\n", util.FUNCTION_NAME_SHORT(), textError.Error())
+		textErrors = append(textErrors, textError)
+
+		if !lenient {
+			return nil, textErrors
+		}
+
+		// The failing line may be partially parsed, so the lines before are parsed again
+		if len(valid) > 0 {
+			parser = expfmt.TextParser{}
+			if metricFamilies, err = parser.TextToMetricFamilies(bytes.NewReader(valid)); err == nil {
+				metricFamiliesList = append(metricFamiliesList, metricFamilies)
+			}
+		}
+		firstLine += parseErr.Line
+		data = rest
+	}
+
+	return metricFamiliesList, textErrors
+}
+
+// Label distinguishing the samples of a series by metric type (see aggregatedFamily)
+var aggregatedSampleLabels = map[string]string{
+	"histogram": model.BucketLabel,
+	"summary":   model.QuantileLabel,
+}
+
+// textGroup is a part of the input, parsed separately (see splitTimestampGroups)
+type textGroup struct {
+	data []byte
+	// Original line numbers (1-based) of the lines in data, nil if data is the whole input
+	lines []int
+}
+
+// Converts a line number of the group to the line number of the input
+func (group textGroup) originalLine(line int) int {
+	switch {
+	case group.lines == nil || line < 1:
+		return line
+	case line > len(group.lines):
+		return group.lines[len(group.lines)-1] + line - len(group.lines)
+	}
+	return group.lines[line-1]
+}
+
+// The text parser merges the lines of a histogram or summary series (having the same labels, except le or quantile),
+// even if their timestamps are different, so only the last timestamp would be kept.
+// The n-th distinct timestamp of each such series is put to the n-th group, which are parsed separately.
+// Each group has only its own lines, the original line numbers are kept in the group.
+// HELP and TYPE lines of a family are put to each group having its samples, other lines are put to the first group.
+func splitTimestampGroups(data []byte) []textGroup {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lineGroups := make([]int, len(lines))
+	metaFamilies := make([]string, len(lines))
+	familyTypes := map[string]string{}
+	seriesGroups := map[string]map[string]int{}
+	seriesLastGroup := map[string]int{}
+	familyLastGroups := map[string]int{}
+	groupCount := 1
+
+	for l, line := range lines {
+		text := strings.TrimSpace(string(line))
+		if strings.HasPrefix(text, "#") {
+			parts := strings.Fields(text)
+			if len(parts) >= 3 && (parts[1] == "HELP" || parts[1] == "TYPE") {
+				metaFamilies[l] = parts[2]
+				if parts[1] == "TYPE" && len(parts) >= 4 {
+					familyTypes[parts[2]] = strings.ToLower(parts[3])
+				}
+			}
+			continue
+		}
+
+		name, labels, rest, err := splitSampleLine(text)
+		if err != nil {
+			continue
+		}
+		family, sampleLabel := aggregatedFamily(name, familyTypes)
+		if family == "" {
+			continue
+		}
+		seriesLabels, ok := labelSetKey(labels, sampleLabel)
+		fields := strings.Fields(rest)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+
+		key := family + "\xff" + seriesLabels
+		group := seriesLastGroup[key]
+		if len(fields) >= 2 {
+			timestampGroups := seriesGroups[key]
+			if timestampGroups == nil {
+				timestampGroups = map[string]int{}
+				seriesGroups[key] = timestampGroups
+			}
+			var has bool
+			if group, has = timestampGroups[fields[1]]; !has {
+				group = len(timestampGroups)
+				timestampGroups[fields[1]] = group
+			}
+		}
+		seriesLastGroup[key] = group
+		lineGroups[l] = group
+		if group > familyLastGroups[family] {
+			familyLastGroups[family] = group
+		}
+		if group >= groupCount {
+			groupCount = group + 1
+		}
+	}
+
+	if groupCount == 1 {
+		return []textGroup{{data: data}}
+	}
+
+	groups := make([]textGroup, groupCount)
+	add := func(g int, l int) {
+		groups[g].data = append(groups[g].data, lines[l]...)
+		groups[g].lines = append(groups[g].lines, l+1)
+	}
+	for l := range lines {
+		if len(lines[l]) == 0 {
+			continue
+		}
+		add(lineGroups[l], l)
+		if metaFamilies[l] != "" {
+			// Groups of a family are continuous, because the groups of each series are
+			for g := 1; g <= familyLastGroups[metaFamilies[l]]; g++ {
+				add(g, l)
+			}
+		}
+	}
+	return groups
+}
+
+// Returns the family name and the sample label, if the sample belongs to an aggregated family, see aggregatedSampleLabels
+func aggregatedFamily(name string, familyTypes map[string]string) (string, string) {
+	for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		family := strings.TrimSuffix(name, suffix)
+		if sampleLabel, has := aggregatedSampleLabels[familyTypes[family]]; has {
+			return family, sampleLabel
+		}
+	}
+	return "", ""
+}
+
+// Label set (including the braces) is converted to an ordered key, without the skipped label
+func labelSetKey(labels string, skipped string) (string, bool) {
+	if len(labels) < 2 {
+		return "", true
+	}
+
+	pairs := []string{}
+	body := labels[1 : len(labels)-1]
+	for {
+		body = strings.TrimLeft(body, " \t,")
+		if body == "" {
+			break
+		}
+		eq := strings.IndexByte(body, '=')
+		if eq < 0 {
+			return "", false
+		}
+		name := strings.TrimSpace(body[:eq])
+		valueLength, ok := quotedValueLength(body[eq+1:])
+		if !ok {
+			return "", false
+		}
+		if name != skipped {
+			pairs = append(pairs, name+"="+strings.TrimSpace(body[eq+1:eq+1+valueLength]))
+		}
+		body = body[eq+1+valueLength:]
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xff"), true
+}
+
+// Returns the data before the line (1-based), the text of the line and the data after the line
+func splitAtLine(data []byte, line int) ([]byte, string, []byte) {
+	start := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(data[start:], '\n')
+		if i < 0 {
+			return data, "", nil
+		}
+		start += i + 1
+	}
+
+	i := bytes.IndexByte(data[start:], '\n')
+	if i < 0 {
+		return data[:start], string(data[start:]), nil
+	}
+	return data[:start], string(data[start : start+i]), data[start+i+1:]
+}
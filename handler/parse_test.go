@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// parsedTimestamps returns the timestamps of the metrics of the family, in parsed order
+func parsedTimestamps(metricFamiliesList []map[string]*dto.MetricFamily, family string) []int64 {
+	timestamps := []int64{}
+	for _, metricFamilies := range metricFamiliesList {
+		if metricFamily, has := metricFamilies[family]; has {
+			for _, metric := range metricFamily.Metric {
+				timestamps = append(timestamps, metric.GetTimestampMs())
+			}
+		}
+	}
+	return timestamps
+}
+
+// errorLines returns the line numbers of the errors
+func errorLines(textErrors []TextError) []int {
+	lines := []int{}
+	for _, textError := range textErrors {
+		lines = append(lines, textError.Line)
+	}
+	return lines
+}
+
+const histogramText = `# HELP http_duration_seconds Request duration.
+# TYPE http_duration_seconds histogram
+http_duration_seconds_bucket{le="0.1"} 1 1000
+http_duration_seconds_bucket{le="+Inf"} 2 1000
+http_duration_seconds_sum 0.3 1000
+http_duration_seconds_count 2 1000
+http_duration_seconds_bucket{le="0.1"} 3 2000
+http_duration_seconds_bucket{le="+Inf"} 4 2000
+http_duration_seconds_sum 0.7 2000
+http_duration_seconds_count 4 2000
+http_duration_seconds_bucket{le="0.1"} 5 3000
+http_duration_seconds_bucket{le="+Inf"} 6 3000
+http_duration_seconds_sum 1.1 3000
+http_duration_seconds_count 6 3000
+`
+
+func TestParseTextHistogramTimestamps(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		family     string
+		timestamps []int64
+		buckets    []uint64
+	}{
+		{"single timestamp", `# TYPE h histogram
+h_bucket{le="+Inf"} 2 1000
+h_sum 1 1000
+h_count 2 1000
+`, "h", []int64{1000}, []uint64{2}},
+		{"more timestamps", histogramText, "http_duration_seconds", []int64{1000, 2000, 3000}, []uint64{2, 4, 6}},
+		{"more series", `# TYPE h histogram
+h_bucket{a="1",le="+Inf"} 1 1000
+h_bucket{a="2",le="+Inf"} 2 1000
+h_bucket{a="1",le="+Inf"} 3 2000
+h_count{a="1"} 3 2000
+h_bucket{a="2",le="+Inf"} 4 2000
+`, "h", []int64{1000, 1000, 2000, 2000}, []uint64{1, 2, 3, 4}},
+		{"without newline at the end", "# TYPE h histogram\nh_count 1 1000\nh_count 2 2000", "h", []int64{1000, 2000}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricFamiliesList, textErrors := ParseText([]byte(test.text), false)
+			if len(textErrors) > 0 {
+				t.Fatalf("unexpected errors: %s", TextErrorsToString(textErrors))
+			}
+			if timestamps := parsedTimestamps(metricFamiliesList, test.family); !reflect.DeepEqual(timestamps, test.timestamps) {
+				t.Errorf("timestamps %v, expected %v", timestamps, test.timestamps)
+			}
+			if test.buckets == nil {
+				return
+			}
+			buckets := []uint64{}
+			for _, metricFamilies := range metricFamiliesList {
+				for _, metric := range metricFamilies[test.family].GetMetric() {
+					bucket := metric.GetHistogram().GetBucket()
+					buckets = append(buckets, bucket[len(bucket)-1].GetCumulativeCount())
+				}
+			}
+			if !reflect.DeepEqual(buckets, test.buckets) {
+				t.Errorf("+Inf buckets %v, expected %v", buckets, test.buckets)
+			}
+		})
+	}
+}
+
+func TestParseTextSummaryTimestamps(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		timestamps []int64
+		counts     []uint64
+		quantiles  [][]float64
+	}{
+		{"single timestamp", `# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.1 1000
+rpc_seconds{quantile="0.9"} 0.2 1000
+rpc_seconds_sum 3 1000
+rpc_seconds_count 10 1000
+`, []int64{1000}, []uint64{10}, [][]float64{{0.1, 0.2}}},
+		{"more timestamps", `# HELP rpc_seconds RPC latency.
+# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.1 1000
+rpc_seconds{quantile="0.9"} 0.2 1000
+rpc_seconds_sum 3 1000
+rpc_seconds_count 10 1000
+rpc_seconds{quantile="0.5"} 0.3 2000
+rpc_seconds{quantile="0.9"} 0.4 2000
+rpc_seconds_sum 7 2000
+rpc_seconds_count 20 2000
+`, []int64{1000, 2000}, []uint64{10, 20}, [][]float64{{0.1, 0.2}, {0.3, 0.4}}},
+		{"more series", `# TYPE rpc_seconds summary
+rpc_seconds{service="a",quantile="0.5"} 0.1 1000
+rpc_seconds{service="b",quantile="0.5"} 0.2 1000
+rpc_seconds_count{service="a"} 1 1000
+rpc_seconds_count{service="b"} 2 1000
+rpc_seconds{service="a",quantile="0.5"} 0.3 2000
+rpc_seconds_count{service="a"} 3 2000
+`, []int64{1000, 1000, 2000}, []uint64{1, 2, 3}, [][]float64{{0.1}, {0.2}, {0.3}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricFamiliesList, textErrors := ParseText([]byte(test.text), false)
+			if len(textErrors) > 0 {
+				t.Fatalf("unexpected errors: %s", TextErrorsToString(textErrors))
+			}
+			if timestamps := parsedTimestamps(metricFamiliesList, "rpc_seconds"); !reflect.DeepEqual(timestamps, test.timestamps) {
+				t.Errorf("timestamps %v, expected %v", timestamps, test.timestamps)
+			}
+			counts := []uint64{}
+			quantiles := [][]float64{}
+			for _, metricFamilies := range metricFamiliesList {
+				for _, metric := range metricFamilies["rpc_seconds"].GetMetric() {
+					counts = append(counts, metric.GetSummary().GetSampleCount())
+					values := []float64{}
+					for _, quantile := range metric.GetSummary().GetQuantile() {
+						values = append(values, quantile.GetValue())
+					}
+					quantiles = append(quantiles, values)
+				}
+			}
+			if !reflect.DeepEqual(counts, test.counts) {
+				t.Errorf("counts %v, expected %v", counts, test.counts)
+			}
+			if !reflect.DeepEqual(quantiles, test.quantiles) {
+				t.Errorf("quantiles %v, expected %v", quantiles, test.quantiles)
+			}
+		})
+	}
+}
+
+func TestParseTextHistogramErrorLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		lenient bool
+		lines   []int
+	}{
+		{"first group", "# TYPE h histogram\nh_count 1 1000\nh_sum x 1000\nh_count 2 2000\n", true, []int{3}},
+		{"later group", "# TYPE h histogram\nh_count 1 1000\nh_count 2 2000\nh_sum x 2000\nh_count 3 3000\n", true, []int{4}},
+		{"more groups", "# TYPE h histogram\nh_count 1 1000\nh_count 2 2000\nh_sum x 2000\nh_count 3 3000\nh_sum y 3000\nbad line\n", true, []int{4, 6, 7}},
+		{"not lenient", "# TYPE h histogram\nh_count 1 1000\nh_count 2 2000\nh_sum x 2000\n", false, []int{4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, textErrors := ParseText([]byte(test.text), test.lenient)
+			if lines := errorLines(textErrors); !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("error lines %v, expected %v: %s", lines, test.lines, TextErrorsToString(textErrors))
+			}
+		})
+	}
+}
+
+func TestSplitTimestampGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		groups []string
+		lines  [][]int
+	}{
+		{"without timestamps", "# TYPE h histogram\nh_count 1\nh_count 2\nc 1 1000\n",
+			[]string{"# TYPE h histogram\nh_count 1\nh_count 2\nc 1 1000\n"}, [][]int{nil}},
+		{"counter timestamps", "# TYPE c counter\nc 1 1000\nc 2 2000\n",
+			[]string{"# TYPE c counter\nc 1 1000\nc 2 2000\n"}, [][]int{nil}},
+		{"histogram timestamps", "# HELP h Help.\n# TYPE h histogram\nh_count 1 1000\nc 1\nh_count 2 2000\n",
+			[]string{"# HELP h Help.\n# TYPE h histogram\nh_count 1 1000\nc 1\n", "# HELP h Help.\n# TYPE h histogram\nh_count 2 2000\n"},
+			[][]int{{1, 2, 3, 4}, {1, 2, 5}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups := splitTimestampGroups([]byte(test.text))
+			texts := []string{}
+			lines := [][]int{}
+			for _, group := range groups {
+				texts = append(texts, string(group.data))
+				lines = append(lines, group.lines)
+			}
+			if !reflect.DeepEqual(texts, test.groups) {
+				t.Errorf("groups %q, expected %q", texts, test.groups)
+			}
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("lines %v, expected %v", lines, test.lines)
+			}
+		})
+	}
+}
+
+// Each line is put to one group only (except HELP and TYPE), so the groups are not larger than the input
+func TestSplitTimestampGroupsSize(t *testing.T) {
+	var text bytes.Buffer
+	text.WriteString("# TYPE h histogram\n")
+	for timestamp := 1; timestamp <= 2000; timestamp++ {
+		fmt.Fprintf(&text, "h_bucket{le=\"+Inf\"} %d %d\nh_count %d %d\n", timestamp, timestamp, timestamp, timestamp)
+	}
+
+	groups := splitTimestampGroups(text.Bytes())
+	if len(groups) != 2000 {
+		t.Fatalf("%d groups, expected 2000", len(groups))
+	}
+	size := 0
+	for _, group := range groups {
+		size += len(group.data)
+	}
+	if size > 2*text.Len() {
+		t.Errorf("groups are %d bytes, input is %d bytes", size, text.Len())
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"bytes"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/prometheus/common/expfmt"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// ParseProtobuf parses the length-delimited protobuf format (expfmt.FmtProtoDelim).
+// Line of TextError is the number of the failing message (1-based).
+// If lenient is false, parsing is stopped at the first error.
+// If lenient is true, the failing message is skipped (if the stream is not truncated).
+// Metric families having the same name are put into different maps.
+func ParseProtobuf(data []byte, lenient bool) ([]map[string]*dto.MetricFamily, []TextError) {
+	metricFamiliesList := []map[string]*dto.MetricFamily{}
+	metricFamilies := map[string]*dto.MetricFamily{}
+	textErrors := []TextError{}
+
+	addError := func(message int, msg string) {
+		textError := TextError{Line: message, Msg: msg}
+		glog.Warningf("%s: %s\n", util.FUNCTION_NAME_SHORT(), textError.Error())
+		textErrors = append(textErrors, textError)
+	}
+
+	for message := 1; len(data) > 0; message++ {
+		// Messages are framed here, so an invalid message can be skipped
+		messageLength, varIntBytes := proto.DecodeVarint(data)
+		if varIntBytes == 0 {
+			addError(message, "invalid message length")
+			break
+		}
+		if uint64(len(data)-varIntBytes) < messageLength {
+			addError(message, "unexpected end of input stream")
+			break
+		}
+		messageEnd := varIntBytes + int(messageLength)
+
+		metricFamily := &dto.MetricFamily{}
+		err := expfmt.NewDecoder(bytes.NewReader(data[:messageEnd]), expfmt.FmtProtoDelim).Decode(metricFamily)
+		data = data[messageEnd:]
+		if err != nil {
+			addError(message, err.Error())
+			if !lenient {
+				break
+			}
+			continue
+		}
+
+		if _, has := metricFamilies[metricFamily.GetName()]; has {
+			metricFamiliesList = append(metricFamiliesList, metricFamilies)
+			metricFamilies = map[string]*dto.MetricFamily{}
+		}
+		metricFamilies[metricFamily.GetName()] = metricFamily
+	}
+	metricFamiliesList = append(metricFamiliesList, metricFamilies)
+
+	if len(textErrors) > 0 && !lenient {
+		return nil, textErrors
+	}
+	return metricFamiliesList, textErrors
+}
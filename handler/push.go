@@ -3,12 +3,18 @@ package handler
 import (
 	"context"
 	//"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 
 	"github.com/spf13/viper"
 
@@ -30,22 +36,277 @@ func HandlePush(w http.ResponseWriter, req *http.Request) {
 	glog.V(1).Infof("%s: %s %s\n", util.FUNCTION_NAME_SHORT(), req.Method, req.URL.String())
 	switch req.Method {
 	case "PUT", "POST":
-		var parser expfmt.TextParser
-		metricFamilies, _ := parser.TextToMetricFamilies(req.Body)
+		importRequest(w, req, nil, false)
+	}
+}
+
+// Request body is imported, groupingLabels are added to every series.
+// In dry run (or dry_run=true query parameter), the WriteRequests are responded instead of sending.
+func importRequest(w http.ResponseWriter, req *http.Request, groupingLabels map[string]string, dryRun bool) {
+	reader, err := EncodedReader(req.Body, req.Header.Get("Content-Encoding"), viper.GetInt64(conf.OPT_MAX_DECOMPRESSED_SIZE))
+	if err != nil {
+		glog.Warningf("%s: Read error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		http.Error(w, err.Error(), encodingErrorStatus(err))
+		return
+	}
+
+	dryRun, err = boolParam(req, conf.PARAM_DRY_RUN, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		glog.V(2).Infof("%s: %v\n", util.FUNCTION_NAME_SHORT(), metricFamilies)
-		util.LogObjAsJson(2, metricFamilies, "metricFamilies", true)
+	options, err := serviceImportOptions(RequestFormat(req.Header))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := requestImportOptions(req, &options); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options.GroupingLabels = groupingLabels
 
-		ProcessSeries(metricFamilies)
+	summary := NewImportSummary()
+	writeRequests := []*prompb.WriteRequest{}
+	if dryRun {
+		err = Import(reader, options, CollectingStore(&writeRequests), summary)
+	} else {
+		err = ProcessSeries(reader, options, summary)
 	}
+	if err != nil {
+		switch err := err.(type) {
+		case TextErrors:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case DuplicateTimestampError, ExternalLabelConflictError:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case ReadError:
+			glog.Warningf("%s: Read error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+			status := http.StatusBadRequest
+			if err.error == ErrTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+		default:
+			writeStoreError(w, err)
+		}
+		return
+	}
+
+	if dryRun {
+		writeDryRun(w, req, writeRequests, summary)
+		return
+	}
+	writeSummary(w, req, summary)
 }
 
-// Timestamp series are listed to labels
-func ProcessSeries(metricFamilies map[string]*dto.MetricFamily) {
-	labelsToSeries := map[string]*prompb.TimeSeries{}
+// Bool mode (for example lenient) can be switched on by service option and by query parameter (lenient=true)
+func boolParam(req *http.Request, param string, value bool) (bool, error) {
+	if paramValue := req.URL.Query().Get(param); paramValue != "" {
+		var err error
+		if value, err = strconv.ParseBool(paramValue); err != nil {
+			return false, fmt.Errorf("invalid %s parameter: %q", param, paramValue)
+		}
+	}
+	return value, nil
+}
 
-	mergeMetrics(labelsToSeries, metricFamilies)
+// Int can be set by service option and by query parameter
+func intParam(req *http.Request, param string, value int) (int, error) {
+	if paramValue := req.URL.Query().Get(param); paramValue != "" {
+		var err error
+		if value, err = strconv.Atoi(paramValue); err != nil {
+			return 0, fmt.Errorf("invalid %s parameter: %q", param, paramValue)
+		}
+	}
+	return value, nil
+}
 
+// Duration can be set by service option and by query parameter (for example stale_after=5m)
+func durationParam(req *http.Request, param string, value time.Duration) (time.Duration, error) {
+	if paramValue := req.URL.Query().Get(param); paramValue != "" {
+		var err error
+		if value, err = time.ParseDuration(paramValue); err != nil {
+			return 0, fmt.Errorf("invalid %s parameter: %q", param, paramValue)
+		}
+	}
+	return value, nil
+}
+
+// Service options are overridden by the query parameters (and headers) of the request
+func requestImportOptions(req *http.Request, options *ImportOptions) error {
+	var err error
+	if options.Lenient, err = boolParam(req, conf.PARAM_LENIENT, options.Lenient); err != nil {
+		return err
+	}
+	if options.Sanitize, err = boolParam(req, conf.PARAM_SANITIZE, options.Sanitize); err != nil {
+		return err
+	}
+
+	if options.Timestamps, err = NewTimestampPolicy(req, options.Timestamps.Missing,
+		conf.PARAM_MISSING_TIMESTAMP, conf.PARAM_BASE_TIMESTAMP,
+	); err != nil {
+		return err
+	}
+
+	query := req.URL.Query()
+	_, hasTimeShift := query[conf.PARAM_TIME_SHIFT]
+	_, hasTimeShiftLabel := query[conf.PARAM_TIME_SHIFT_LABEL]
+	if hasTimeShift || hasTimeShiftLabel {
+		if options.TimeShift, err = ParseTimeShift(
+			queryOrDefault(req, conf.PARAM_TIME_SHIFT, viper.GetString(conf.OPT_TIME_SHIFT)),
+			queryOrDefault(req, conf.PARAM_TIME_SHIFT_LABEL, viper.GetString(conf.OPT_TIME_SHIFT_LABEL)),
+		); err != nil {
+			return err
+		}
+	}
+
+	if options.Duplicates = queryOrDefault(req, conf.PARAM_DUPLICATE_TIMESTAMP, options.Duplicates); !IsDuplicatePolicy(options.Duplicates) {
+		return fmt.Errorf("invalid %s parameter: %q", conf.PARAM_DUPLICATE_TIMESTAMP, options.Duplicates)
+	}
+
+	if options.StaleAfter, err = durationParam(req, conf.PARAM_STALE_AFTER, options.StaleAfter); err != nil {
+		return err
+	}
+
+	return requestExternalLabels(req, &options.ExternalLabels)
+}
+
+// External labels of the service are overridden by X-Extra-Labels header, which is overridden by the query parameter
+func requestExternalLabels(req *http.Request, externalLabels *ExternalLabels) error {
+	externalLabels.Conflict = queryOrDefault(req, conf.PARAM_EXTERNAL_LABELS_CONFLICT, externalLabels.Conflict)
+	if !IsExternalLabelsConflict(externalLabels.Conflict) {
+		return fmt.Errorf("invalid %s parameter: %q", conf.PARAM_EXTERNAL_LABELS_CONFLICT, externalLabels.Conflict)
+	}
+
+	if err := ParseLabels(req.Header.Get(HEADER_EXTRA_LABELS), externalLabels.Labels); err != nil {
+		return fmt.Errorf("invalid %s header: %s", HEADER_EXTRA_LABELS, err)
+	}
+	for _, value := range req.URL.Query()[conf.PARAM_EXTRA_LABELS] {
+		if err := ParseLabels(value, externalLabels.Labels); err != nil {
+			return fmt.Errorf("invalid %s parameter: %s", conf.PARAM_EXTRA_LABELS, err)
+		}
+	}
+	return nil
+}
+
+// Query parameter overrides the service option
+func queryOrDefault(req *http.Request, param string, value string) string {
+	if values, ok := req.URL.Query()[param]; ok && len(values) > 0 {
+		return values[0]
+	}
+	return value
+}
+
+// ServiceImportOptions builds the import options from the service options (without query parameters)
+// for streaming the input, relabel configs must be loaded before (see LoadRelabelConfigs)
+func ServiceImportOptions(format expfmt.Format) (ImportOptions, error) {
+	options, err := serviceImportOptions(format)
+	if err != nil {
+		return ImportOptions{}, err
+	}
+	if options.Timestamps.Missing == MISSING_TIMESTAMP_BASE_TIME {
+		// Base time can be set only by the request, see NewTimestampPolicy
+		return ImportOptions{}, fmt.Errorf("invalid %s option without request: %q", conf.OPT_MISSING_TIMESTAMP, options.Timestamps.Missing)
+	}
+	if options.TimeShift.Newest {
+		// The whole input would be buffered, see ImportOptions.TimeShift
+		return ImportOptions{}, fmt.Errorf("invalid %s option for streaming: %q", conf.OPT_TIME_SHIFT, viper.GetString(conf.OPT_TIME_SHIFT))
+	}
+	return options, nil
+}
+
+// Base time policy is accepted, the base time is set by the request (see requestImportOptions)
+func serviceImportOptions(format expfmt.Format) (ImportOptions, error) {
+	timestamps := TimestampPolicy{
+		Missing:       viper.GetString(conf.OPT_MISSING_TIMESTAMP),
+		ReceiveTimeMs: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	switch timestamps.Missing {
+	case MISSING_TIMESTAMP_REJECT, MISSING_TIMESTAMP_RECEIVE_TIME, MISSING_TIMESTAMP_BASE_TIME:
+	default:
+		return ImportOptions{}, fmt.Errorf("invalid %s option: %q", conf.OPT_MISSING_TIMESTAMP, timestamps.Missing)
+	}
+
+	timeShift, err := ParseTimeShift(viper.GetString(conf.OPT_TIME_SHIFT), viper.GetString(conf.OPT_TIME_SHIFT_LABEL))
+	if err != nil {
+		return ImportOptions{}, err
+	}
+
+	duplicates := viper.GetString(conf.OPT_DUPLICATE_TIMESTAMP)
+	if !IsDuplicatePolicy(duplicates) {
+		return ImportOptions{}, fmt.Errorf("invalid %s option: %q", conf.OPT_DUPLICATE_TIMESTAMP, duplicates)
+	}
+
+	externalLabels := ExternalLabels{
+		Labels:   map[string]string{},
+		Conflict: viper.GetString(conf.OPT_EXTERNAL_LABELS_CONFLICT),
+	}
+	if !IsExternalLabelsConflict(externalLabels.Conflict) {
+		return ImportOptions{}, fmt.Errorf("invalid %s option: %q", conf.OPT_EXTERNAL_LABELS_CONFLICT, externalLabels.Conflict)
+	}
+	if err := ParseLabels(viper.GetString(conf.OPT_EXTERNAL_LABELS), externalLabels.Labels); err != nil {
+		return ImportOptions{}, fmt.Errorf("invalid %s option: %s", conf.OPT_EXTERNAL_LABELS, err)
+	}
+
+	return ImportOptions{
+		Format:       format,
+		Lenient:      viper.GetBool(conf.OPT_LENIENT),
+		Sanitize:     viper.GetBool(conf.OPT_SANITIZE),
+		FlushSamples: viper.GetInt(conf.OPT_FLUSH_SAMPLES),
+		FlushBytes:   viper.GetInt64(conf.OPT_FLUSH_BYTES),
+		Limits: BatchLimits{
+			MaxSamples: viper.GetInt(conf.OPT_MAX_SAMPLES_PER_REQUEST),
+			MaxSeries:  viper.GetInt(conf.OPT_MAX_SERIES_PER_REQUEST),
+			MaxBytes:   viper.GetInt(conf.OPT_MAX_BYTES_PER_REQUEST),
+		},
+		Timestamps: timestamps,
+		TimeShift:  timeShift,
+		Duplicates: duplicates,
+
+		RelabelConfigs: relabelConfigs,
+		ExternalLabels: externalLabels,
+		StaleAfter:     viper.GetDuration(conf.OPT_STALE_AFTER),
+	}, nil
+}
+
+// Store error is mapped to HTTP status:
+// recoverable error (network error or 5xx): 503 with Retry-After,
+// 400 from the target: 400,
+// other non-2xx from the target: 422,
+// other errors: 500
+func writeStoreError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	msg := err.Error()
+	if remote.IsRecoverable(err) {
+		status = http.StatusServiceUnavailable
+		retryAfter := viper.GetDuration(conf.OPT_RETRY_AFTER)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	} else if httpErr, ok := remote.HTTPErrorOf(err); ok {
+		if httpErr.StatusCode == http.StatusBadRequest {
+			status = http.StatusBadRequest
+		} else {
+			status = http.StatusUnprocessableEntity
+		}
+		msg = httpErr.Msg
+	}
+
+	glog.Warningf("%s: Store error, responding %d: %+v\n", util.FUNCTION_NAME_SHORT(), status, err)
+	http.Error(w, msg, status)
+}
+
+// Input is imported to the remote_write target
+func ProcessSeries(r io.Reader, options ImportOptions, summary *ImportSummary) error {
+	store, err := NewRemoteStore()
+	if err != nil {
+		return err
+	}
+
+	return Import(r, options, store, summary)
+}
+
+// NewRemoteStore returns a StoreFunc sending to the remote_write target
+func NewRemoteStore() (StoreFunc, error) {
 	serverURL, err := url.Parse(viper.GetString(conf.OPT_WRITE_TO))
 	if err != nil {
 		util.PrintFatalf("Runtime error: %+v\n", err)
@@ -54,30 +315,42 @@ func ProcessSeries(metricFamilies map[string]*dto.MetricFamily) {
 
 	cc := remote.ClientConfig{
 		URL:     serverURL,
-		Timeout: time.Second,
+		Timeout: viper.GetDuration(conf.OPT_REMOTE_TIMEOUT),
 	}
 
 	c, err := remote.NewClient(0, &cc)
 	if err != nil {
 		glog.Warningf("%s: Client error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
-		return
+		return nil, err
 	}
 
-	writeRequest := SeriesToWriteRequest(labelsToSeries)
-	util.LogObjAsJson(2, writeRequest, "writeRequest", true)
-	err = c.Store(context.Background(), writeRequest)
-	if err != nil {
-		glog.Warningf("%s: Store error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
-	}
+	return func(writeRequest *prompb.WriteRequest) error {
+		err := c.Store(context.Background(), writeRequest)
+		if err != nil {
+			glog.Warningf("%s: Store error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		}
+		return err
+	}, nil
 }
 
 // Idea from github.com/prometheus/prometheus/storage/remote/codec.go:ToWriteRequest
+// Series are ordered by labels, samples are ordered by timestamp.
 func SeriesToWriteRequest(series map[string]*prompb.TimeSeries) *prompb.WriteRequest {
 	req := &prompb.WriteRequest{
 		Timeseries: make([]*prompb.TimeSeries, 0, len(series)),
 	}
 
-	for _, s := range series {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := series[k]
+		sort.SliceStable(s.Samples, func(i, j int) bool {
+			return s.Samples[i].Timestamp < s.Samples[j].Timestamp
+		})
 		glog.V(2).Infof("%s: serie %v\n", util.FUNCTION_NAME_SHORT(), s)
 		req.Timeseries = append(req.Timeseries, s)
 	}
@@ -87,29 +360,15 @@ func SeriesToWriteRequest(series map[string]*prompb.TimeSeries) *prompb.WriteReq
 
 // Idea from github.com/prometheus/prometheus/documentation/
 //           examples/remote_storage/remote_storage_adapter/influxdb/client.go:mergeResult
-func mergeMetrics(labelsToSeries map[string]*prompb.TimeSeries, metricFamilies map[string]*dto.MetricFamily) error {
+func mergeMetrics(labelsToSeries map[string]*prompb.TimeSeries, metricFamilies map[string]*dto.MetricFamily,
+	summary *ImportSummary,
+) error {
 	for _, m := range metricFamilies {
 		name := m.GetName()
+		summary.addFamily(name)
 		glog.V(2).Infof("%s: name = %v (%v)\n", util.FUNCTION_NAME_SHORT(), name, m.String())
-		switch m.GetType() {
-		case dto.MetricType_HISTOGRAM, dto.MetricType_SUMMARY:
-			glog.Warningf("%s: Not supported metric type: %v, %v\n", util.FUNCTION_NAME_SHORT(),
-				m.String(), m,
-			)
-			continue
-		}
 		for _, s := range m.GetMetric() {
 			glog.V(2).Infof("%s: s.GetLabel() = %v\n", util.FUNCTION_NAME_SHORT(), s.GetLabel())
-			k := concatLabels(name, s.GetLabel())
-			glog.V(2).Infof("%s: k = %v\n", util.FUNCTION_NAME_SHORT(), k)
-			ts, ok := labelsToSeries[k]
-			if !ok {
-				ts = &prompb.TimeSeries{
-					Labels: tagsToLabelPairs(name, s.GetLabel()),
-				}
-				labelsToSeries[k] = ts
-			}
-
 			value := float64(0)
 			switch m.GetType() {
 			case dto.MetricType_COUNTER:
@@ -118,13 +377,21 @@ func mergeMetrics(labelsToSeries map[string]*prompb.TimeSeries, metricFamilies m
 				value = s.GetGauge().GetValue()
 			case dto.MetricType_UNTYPED:
 				value = s.GetUntyped().GetValue()
+			case dto.MetricType_SUMMARY:
+				mergeSummary(labelsToSeries, name, s)
+				continue
+			case dto.MetricType_HISTOGRAM:
+				mergeHistogram(labelsToSeries, name, s)
+				continue
+			default:
+				glog.Warningf("%s: Not supported metric type: %v, %v\n", util.FUNCTION_NAME_SHORT(),
+					m.String(), m,
+				)
+				summary.addDropped(DROP_UNSUPPORTED_TYPE, 1)
+				continue
 			}
 
-			ts.Samples = append(ts.Samples, &prompb.Sample{
-				Timestamp: s.GetTimestampMs(),
-				Value:     value,
-			})
-			glog.V(2).Infof("%s: ts = %v (%v)\n", util.FUNCTION_NAME_SHORT(), ts, m.String())
+			appendSample(labelsToSeries, name, s.GetLabel(), s.GetTimestampMs(), value)
 		}
 		glog.V(2).Infof("%s:\n labelsToSeries = %v\n\n", util.FUNCTION_NAME_SHORT(), labelsToSeries)
 
@@ -135,6 +402,83 @@ func mergeMetrics(labelsToSeries map[string]*prompb.TimeSeries, metricFamilies m
 	return nil
 }
 
+// Summary is expanded to {quantile="..."}, _sum and _count series.
+// Idea from github.com/prometheus/common/expfmt/text_create.go:MetricFamilyToText
+func mergeSummary(labelsToSeries map[string]*prompb.TimeSeries, name string, s *dto.Metric) {
+	summary := s.GetSummary()
+	timestampMs := s.GetTimestampMs()
+
+	for _, q := range summary.GetQuantile() {
+		appendSample(labelsToSeries, name,
+			addLabel(s.GetLabel(), model.QuantileLabel, fmt.Sprint(q.GetQuantile())),
+			timestampMs, q.GetValue(),
+		)
+	}
+
+	appendSample(labelsToSeries, name+"_sum", s.GetLabel(), timestampMs, summary.GetSampleSum())
+	appendSample(labelsToSeries, name+"_count", s.GetLabel(), timestampMs, float64(summary.GetSampleCount()))
+}
+
+// Histogram is expanded to _bucket{le="..."}, _sum and _count series.
+// Idea from github.com/prometheus/common/expfmt/text_create.go:MetricFamilyToText
+// (the +Inf bucket is added, if it's missing)
+func mergeHistogram(labelsToSeries map[string]*prompb.TimeSeries, name string, s *dto.Metric) {
+	h := s.GetHistogram()
+	timestampMs := s.GetTimestampMs()
+
+	hasInfBucket := false
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			hasInfBucket = true
+		}
+		appendSample(labelsToSeries, name+"_bucket",
+			addLabel(s.GetLabel(), model.BucketLabel, fmt.Sprint(b.GetUpperBound())),
+			timestampMs, float64(b.GetCumulativeCount()),
+		)
+	}
+	if !hasInfBucket {
+		appendSample(labelsToSeries, name+"_bucket",
+			addLabel(s.GetLabel(), model.BucketLabel, "+Inf"),
+			timestampMs, float64(h.GetSampleCount()),
+		)
+	}
+
+	appendSample(labelsToSeries, name+"_sum", s.GetLabel(), timestampMs, h.GetSampleSum())
+	appendSample(labelsToSeries, name+"_count", s.GetLabel(), timestampMs, float64(h.GetSampleCount()))
+}
+
+// Sample is appended to the series of name and labels. The series is created, if it's missing.
+func appendSample(labelsToSeries map[string]*prompb.TimeSeries, name string, labels []*dto.LabelPair,
+	timestampMs int64, value float64,
+) {
+	k := concatLabels(name, labels)
+	glog.V(2).Infof("%s: k = %v\n", util.FUNCTION_NAME_SHORT(), k)
+	ts, ok := labelsToSeries[k]
+	if !ok {
+		ts = &prompb.TimeSeries{
+			Labels: tagsToLabelPairs(name, labels),
+		}
+		labelsToSeries[k] = ts
+	}
+
+	ts.Samples = append(ts.Samples, &prompb.Sample{
+		Timestamp: timestampMs,
+		Value:     value,
+	})
+	glog.V(2).Infof("%s: ts = %v\n", util.FUNCTION_NAME_SHORT(), ts)
+}
+
+// New label is appended to a copy of labels
+func addLabel(labels []*dto.LabelPair, name string, value string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels)+1)
+	pairs = append(pairs, labels...)
+	pairs = append(pairs, &dto.LabelPair{
+		Name:  proto.String(name),
+		Value: proto.String(value),
+	})
+	return pairs
+}
+
 // Idea from github.com/prometheus/prometheus/documentation/
 //           examples/remote_storage/remote_storage_adapter/influxdb/client.go:tagsToLabelPairs
 func tagsToLabelPairs(name string, labels []*dto.LabelPair) []*prompb.Label {
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/prometheus/common/model"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Pushgateway grouping key, see https://github.com/prometheus/pushgateway#url
+const (
+	PUSHGATEWAY_JOB    = "job"
+	PUSHGATEWAY_BASE64 = "@base64"
+)
+
+// HandlePushgateway receives on Pushgateway-compatible paths: /job/<job>{/<label>/<value>}
+// (the path prefix must be stripped). The grouping labels are added to every pushed series.
+func HandlePushgateway(w http.ResponseWriter, req *http.Request) {
+	glog.V(1).Infof("%s: %s %s\n", util.FUNCTION_NAME_SHORT(), req.Method, req.URL.String())
+	switch req.Method {
+	case "PUT", "POST":
+		groupingLabels, err := ParseGroupingKey(req.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		importRequest(w, req, groupingLabels, false)
+	default:
+		http.Error(w, "only pushing (PUT, POST) is supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// ParseGroupingKey parses the label/value pairs of the path, the first label must be the job.
+// The value of a label with @base64 suffix is base64url encoded ("=" is the empty value).
+func ParseGroupingKey(path string) (map[string]string, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("odd number of label/value elements in path: %q", path)
+	}
+	if strings.TrimSuffix(parts[0], PUSHGATEWAY_BASE64) != PUSHGATEWAY_JOB {
+		return nil, fmt.Errorf("missing %s in path: %q", PUSHGATEWAY_JOB, path)
+	}
+
+	groupingLabels := map[string]string{}
+	for p := 0; p < len(parts); p += 2 {
+		name, value := parts[p], parts[p+1]
+		if strings.HasSuffix(name, PUSHGATEWAY_BASE64) {
+			name = strings.TrimSuffix(name, PUSHGATEWAY_BASE64)
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value of label %s: %q", name, value)
+			}
+			value = string(decoded)
+		}
+
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name in path: %q", name)
+		}
+		if _, has := groupingLabels[name]; has {
+			return nil, fmt.Errorf("duplicate label name in path: %q", name)
+		}
+		if name == PUSHGATEWAY_JOB && value == "" {
+			return nil, fmt.Errorf("empty %s in path", PUSHGATEWAY_JOB)
+		}
+		groupingLabels[name] = value
+	}
+
+	return groupingLabels, nil
+}
+
+// Labels are set on every metric, existing labels with the same name are overridden
+func setLabels(metricFamilies map[string]*dto.MetricFamily, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, m := range metricFamilies {
+		for _, s := range m.GetMetric() {
+			for _, name := range names {
+				s.Label = setLabelPair(s.Label, name, labels[name])
+			}
+		}
+	}
+}
+
+// Value of the label is set, the label is appended, if it's missing.
+// An empty value is the same to a missing label, so the label is deleted.
+func setLabelPair(labels []*dto.LabelPair, name string, value string) []*dto.LabelPair {
+	for l, label := range labels {
+		if label.GetName() == name {
+			if value == "" {
+				return append(labels[:l], labels[l+1:]...)
+			}
+			label.Value = proto.String(value)
+			return labels
+		}
+	}
+	if value == "" {
+		return labels
+	}
+	return append(labels, &dto.LabelPair{
+		Name:  proto.String(name),
+		Value: proto.String(value),
+	})
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseGroupingKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected map[string]string
+		failed   bool
+	}{
+		{"job", "/job/backup", map[string]string{"job": "backup"}, false},
+		{"labels", "/job/backup/instance/db-1/env/prod/", map[string]string{"job": "backup", "instance": "db-1", "env": "prod"}, false},
+		{"base64 job", "/job@base64/YmFja3VwL2RhaWx5", map[string]string{"job": "backup/daily"}, false},
+		{"base64 with padding", "/job/backup/path@base64/L3Zhci90bXA=", map[string]string{"job": "backup", "path": "/var/tmp"}, false},
+		{"base64 without padding", "/job/backup/path@base64/L3Zhci90bXA", map[string]string{"job": "backup", "path": "/var/tmp"}, false},
+		{"base64 empty value", "/job/backup/instance@base64/=", map[string]string{"job": "backup", "instance": ""}, false},
+		{"empty value", "/job/backup/instance//env/prod", map[string]string{"job": "backup", "instance": "", "env": "prod"}, false},
+		{"empty path", "/", nil, true},
+		{"missing job", "/instance/db-1", nil, true},
+		{"missing value", "/job/backup/instance", nil, true},
+		{"empty job", "/job//instance/db-1", nil, true},
+		{"empty base64 job", "/job@base64/=", nil, true},
+		{"invalid base64", "/job/backup/instance@base64/!!", nil, true},
+		{"invalid label name", "/job/backup/in-stance/db-1", nil, true},
+		{"reserved label name", "/job/backup/__name__/x", nil, true},
+		{"duplicate label", "/job/backup/instance/a/instance@base64/Yg", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groupingLabels, err := ParseGroupingKey(test.path)
+			if (err != nil) != test.failed {
+				t.Fatalf("error %v, expected failure %v", err, test.failed)
+			}
+			if !reflect.DeepEqual(groupingLabels, test.expected) {
+				t.Errorf("labels %v, expected %v", groupingLabels, test.expected)
+			}
+		})
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   []string
+		set      map[string]string
+		expected []string
+	}{
+		{"added", []string{"a", "1"}, map[string]string{"job": "x"}, []string{"a", "1", "job", "x"}},
+		{"overridden", []string{"job", "y", "a", "1"}, map[string]string{"job": "x"}, []string{"job", "x", "a", "1"}},
+		{"empty value deletes", []string{"instance", "db-1", "a", "1"}, map[string]string{"instance": ""}, []string{"a", "1"}},
+		{"empty value of missing label", []string{"a", "1"}, map[string]string{"instance": ""}, []string{"a", "1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metric := &dto.Metric{}
+			for l := 0; l+1 < len(test.labels); l += 2 {
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(test.labels[l]), Value: proto.String(test.labels[l+1])})
+			}
+			setLabels(map[string]*dto.MetricFamily{"m": {Metric: []*dto.Metric{metric}}}, test.set)
+
+			labels := []string{}
+			for _, label := range metric.Label {
+				labels = append(labels, label.GetName(), label.GetValue())
+			}
+			if !reflect.DeepEqual(labels, test.expected) {
+				t.Errorf("labels %v, expected %v", labels, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/relabel"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Relabel configs of the service, see LoadRelabelConfigs
+var relabelConfigs []*relabel.Config
+
+// LoadRelabelConfigs loads the relabel configs of the service from a YAML file (empty filename: no relabeling)
+func LoadRelabelConfigs(filename string) error {
+	if filename == "" {
+		relabelConfigs = nil
+		return nil
+	}
+
+	configs, err := relabel.LoadFile(filename)
+	if err != nil {
+		return err
+	}
+	glog.Infof("%s: %d relabel configs loaded from %s\n", util.FUNCTION_NAME_SHORT(), len(configs), filename)
+	relabelConfigs = configs
+	return nil
+}
+
+// Series are relabeled, samples of dropped series are counted as filtered.
+// Series having the same labels after relabeling are merged.
+func relabelSeries(labelsToSeries map[string]*prompb.TimeSeries, configs []*relabel.Config,
+	summary *ImportSummary,
+) map[string]*prompb.TimeSeries {
+	if len(configs) == 0 {
+		return labelsToSeries
+	}
+
+	relabeled := make(map[string]*prompb.TimeSeries, len(labelsToSeries))
+	for _, ts := range labelsToSeries {
+		labels := relabel.Process(ts.Labels, configs...)
+		if labels == nil {
+			glog.V(2).Infof("%s: dropped %v\n", util.FUNCTION_NAME_SHORT(), ts.Labels)
+			summary.addDropped(DROP_FILTERED, len(ts.Samples))
+			continue
+		}
+
+		k := labelsKey(labels)
+		if merged, ok := relabeled[k]; ok {
+			merged.Samples = append(merged.Samples, ts.Samples...)
+			continue
+		}
+		ts.Labels = labels
+		relabeled[k] = ts
+	}
+	return relabeled
+}
+
+// Labels must be ordered, see concatLabels
+func labelsKey(labels []*prompb.Label) string {
+	separator := "\xff"
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label.Name+separator+label.Value)
+	}
+	return strings.Join(pairs, separator)
+}
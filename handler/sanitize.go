@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Kinds of renamed names
+const (
+	RENAME_METRIC = "metric"
+	RENAME_LABEL  = "label"
+)
+
+// Rename is a repaired name, reported in the import summary
+type Rename struct {
+	Kind  string `json:"kind"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int    `json:"count"`
+}
+
+// NameCollision is reported, if different names are sanitized to the same name
+type NameCollision struct {
+	Kind string   `json:"kind"`
+	Name string   `json:"name"`
+	From []string `json:"from"`
+}
+
+// SanitizeMetricName replaces invalid characters with '_' and prefixes the name with '_', if it starts with a digit
+func SanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// SanitizeLabelName replaces invalid characters with '_' and prefixes the name with '_', if it starts with a digit
+func SanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+// See model.IsValidMetricName and model.LabelName.IsValid.
+// Characters are replaced per rune, so a multi-byte character (or an invalid UTF-8 byte) is replaced with one '_'.
+func sanitizeName(name string, colonValid bool) string {
+	if name == "" {
+		return name
+	}
+	sanitized := make([]byte, 0, len(name))
+	for _, c := range name {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' ||
+			(c >= '0' && c <= '9') || (colonValid && c == ':')
+		if !valid {
+			c = '_'
+		}
+		sanitized = append(sanitized, byte(c))
+	}
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		return "_" + string(sanitized)
+	}
+	return string(sanitized)
+}
+
+// Names of the text (or OpenMetrics) lines are sanitized, line numbers are kept
+func sanitizeText(data []byte, summary *ImportSummary) []byte {
+	lines := strings.Split(string(data), "\n")
+	changed := false
+	for l, line := range lines {
+		if sanitized := sanitizeLine(line, summary); sanitized != line {
+			lines[l] = sanitized
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Invalid lines are kept, so the parser reports them
+func sanitizeLine(line string, summary *ImportSummary) string {
+	if line == "" {
+		return line
+	}
+
+	if strings.HasPrefix(line, "#") {
+		parts := strings.SplitN(line, " ", 4)
+		if len(parts) < 3 || parts[0] != "#" || (parts[1] != "HELP" && parts[1] != "TYPE" && parts[1] != "UNIT") {
+			return line
+		}
+		parts[2] = sanitizeMetricName(parts[2], summary)
+		return strings.Join(parts, " ")
+	}
+
+	name, labels, rest, err := splitSampleLine(line)
+	if err != nil {
+		return line
+	}
+	return sanitizeMetricName(name, summary) + sanitizeLabelSet(labels, summary) + rest
+}
+
+// Label set includes the braces, invalid label set is kept
+func sanitizeLabelSet(labels string, summary *ImportSummary) string {
+	if len(labels) < 2 {
+		return labels
+	}
+
+	var sanitized bytes.Buffer
+	sanitized.WriteByte('{')
+	body := labels[1 : len(labels)-1]
+	for {
+		trimmed := strings.TrimLeft(body, " \t")
+		sanitized.WriteString(body[:len(body)-len(trimmed)])
+		body = trimmed
+		if body == "" {
+			break
+		}
+
+		eq := strings.IndexByte(body, '=')
+		if eq < 0 {
+			return labels
+		}
+		name := strings.TrimRight(body[:eq], " \t")
+		sanitized.WriteString(sanitizeLabelName(name, summary))
+		sanitized.WriteString(body[len(name):eq])
+
+		value, ok := quotedValueLength(body[eq+1:])
+		if !ok {
+			return labels
+		}
+		sanitized.WriteString(body[eq : eq+1+value])
+		body = body[eq+1+value:]
+
+		trimmed = strings.TrimLeft(body, " \t")
+		sanitized.WriteString(body[:len(body)-len(trimmed)])
+		body = trimmed
+		if strings.HasPrefix(body, ",") {
+			sanitized.WriteByte(',')
+			body = body[1:]
+		}
+	}
+	sanitized.WriteByte('}')
+	return sanitized.String()
+}
+
+// Length of the leading spaces and the quoted value (including quotes)
+func quotedValueLength(s string) (int, bool) {
+	start := len(s) - len(strings.TrimLeft(s, " \t"))
+	if start >= len(s) || s[start] != '"' {
+		return 0, false
+	}
+	escaped := false
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// Names of the length-delimited protobuf messages are sanitized, invalid messages are kept
+func sanitizeProtobuf(data []byte, summary *ImportSummary) []byte {
+	sanitized := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		messageLength, varIntBytes := proto.DecodeVarint(data)
+		if varIntBytes == 0 || uint64(len(data)-varIntBytes) < messageLength {
+			return append(sanitized, data...)
+		}
+		messageEnd := varIntBytes + int(messageLength)
+		message := data[:messageEnd]
+		data = data[messageEnd:]
+
+		metricFamily := &dto.MetricFamily{}
+		if err := proto.Unmarshal(message[varIntBytes:], metricFamily); err != nil {
+			sanitized = append(sanitized, message...)
+			continue
+		}
+		if !sanitizeMetricFamily(metricFamily, summary) {
+			sanitized = append(sanitized, message...)
+			continue
+		}
+		buffer := proto.NewBuffer(nil)
+		if err := buffer.EncodeMessage(metricFamily); err != nil {
+			sanitized = append(sanitized, message...)
+			continue
+		}
+		sanitized = append(sanitized, buffer.Bytes()...)
+	}
+	return sanitized
+}
+
+// Returns true, if a name is changed
+func sanitizeMetricFamily(metricFamily *dto.MetricFamily, summary *ImportSummary) bool {
+	changed := false
+	if name := sanitizeMetricName(metricFamily.GetName(), summary); name != metricFamily.GetName() {
+		metricFamily.Name = proto.String(name)
+		changed = true
+	}
+	for _, s := range metricFamily.GetMetric() {
+		for _, label := range s.GetLabel() {
+			if name := sanitizeLabelName(label.GetName(), summary); name != label.GetName() {
+				label.Name = proto.String(name)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func sanitizeMetricName(name string, summary *ImportSummary) string {
+	sanitized := SanitizeMetricName(name)
+	summary.addSanitized(RENAME_METRIC, name, sanitized)
+	if sanitized != name {
+		glog.V(1).Infof("%s: metric %q renamed to %q\n", util.FUNCTION_NAME_SHORT(), name, sanitized)
+		summary.addRename(RENAME_METRIC, name, sanitized)
+	}
+	return sanitized
+}
+
+func sanitizeLabelName(name string, summary *ImportSummary) string {
+	sanitized := SanitizeLabelName(name)
+	summary.addSanitized(RENAME_LABEL, name, sanitized)
+	if sanitized != name {
+		glog.V(1).Infof("%s: label %q renamed to %q\n", util.FUNCTION_NAME_SHORT(), name, sanitized)
+		summary.addRename(RENAME_LABEL, name, sanitized)
+	}
+	return sanitized
+}
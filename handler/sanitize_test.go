@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		metric string
+		label  string
+	}{
+		{"empty", "", "", ""},
+		{"valid", "http_requests_total", "http_requests_total", "http_requests_total"},
+		{"colon", "job:requests:rate5m", "job:requests:rate5m", "job_requests_rate5m"},
+		{"dash and dot", "http-requests.total", "http_requests_total", "http_requests_total"},
+		{"leading digit", "5xx_errors", "_5xx_errors", "_5xx_errors"},
+		{"multi-byte character", "temp_°C", "temp__C", "temp__C"},
+		{"invalid UTF-8", "a\xffb", "a_b", "a_b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if metric := SanitizeMetricName(test.input); metric != test.metric {
+				t.Errorf("metric name %q, expected %q", metric, test.metric)
+			}
+			if label := SanitizeLabelName(test.input); label != test.label {
+				t.Errorf("label name %q, expected %q", label, test.label)
+			}
+		})
+	}
+}
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		output     string
+		renames    []Rename
+		collisions []NameCollision
+	}{
+		{
+			name:   "valid",
+			input:  "# TYPE m counter\nm{a=\"1\"} 1\n",
+			output: "# TYPE m counter\nm{a=\"1\"} 1\n",
+		},
+		{
+			name:   "metric and label",
+			input:  "# HELP my-metric Help.\n# TYPE my-metric gauge\nmy-metric{app.name=\"x-y\", 1st=\"z\"} 1 1000\nmy-metric 2\n",
+			output: "# HELP my_metric Help.\n# TYPE my_metric gauge\nmy_metric{app_name=\"x-y\", _1st=\"z\"} 1 1000\nmy_metric 2\n",
+			renames: []Rename{
+				{Kind: RENAME_METRIC, From: "my-metric", To: "my_metric", Count: 4},
+				{Kind: RENAME_LABEL, From: "app.name", To: "app_name", Count: 1},
+				{Kind: RENAME_LABEL, From: "1st", To: "_1st", Count: 1},
+			},
+		},
+		{
+			name:   "label value is kept",
+			input:  "m{a=\"b.c=d,e\"} 1\n",
+			output: "m{a=\"b.c=d,e\"} 1\n",
+		},
+		{
+			name:   "invalid lines are kept",
+			input:  "# some comment\nm-1{a.b=\"x} 1\nm-2 {\n",
+			output: "# some comment\nm-1{a.b=\"x} 1\nm_2 {\n",
+			renames: []Rename{
+				{Kind: RENAME_METRIC, From: "m-2", To: "m_2", Count: 1},
+			},
+		},
+		{
+			name:   "collisions",
+			input:  "a.b 1\na-b 2\na_b 3\nm{x.y=\"1\",x-y=\"2\"} 1\n",
+			output: "a_b 1\na_b 2\na_b 3\nm{x_y=\"1\",x_y=\"2\"} 1\n",
+			renames: []Rename{
+				{Kind: RENAME_METRIC, From: "a.b", To: "a_b", Count: 1},
+				{Kind: RENAME_METRIC, From: "a-b", To: "a_b", Count: 1},
+				{Kind: RENAME_LABEL, From: "x.y", To: "x_y", Count: 1},
+				{Kind: RENAME_LABEL, From: "x-y", To: "x_y", Count: 1},
+			},
+			collisions: []NameCollision{
+				{Kind: RENAME_METRIC, Name: "a_b", From: []string{"a.b", "a-b", "a_b"}},
+				{Kind: RENAME_LABEL, Name: "x_y", From: []string{"x.y", "x-y"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary := NewImportSummary()
+			if output := string(sanitizeText([]byte(test.input), summary)); output != test.output {
+				t.Errorf("output %q, expected %q", output, test.output)
+			}
+			if !reflect.DeepEqual(summary.Renames, test.renames) {
+				t.Errorf("renames %+v, expected %+v", summary.Renames, test.renames)
+			}
+			if !reflect.DeepEqual(summary.NameCollisions, test.collisions) {
+				t.Errorf("collisions %+v, expected %+v", summary.NameCollisions, test.collisions)
+			}
+		})
+	}
+}
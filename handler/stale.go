@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/remote"
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Last sent sample of a series
+type lastSample struct {
+	labels      []*prompb.Label
+	timestampMs int64
+}
+
+// Last timestamps of the sent series are tracked for staleness markers and for samples of later flushes
+func (imp *importer) trackLastSamples(writeRequest *prompb.WriteRequest) {
+	for _, ts := range writeRequest.Timeseries {
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		// Samples are ordered by timestamp
+		timestampMs := ts.Samples[len(ts.Samples)-1].Timestamp
+		k := labelsKey(ts.Labels)
+		if last, has := imp.lastSamples[k]; !has || timestampMs > last.timestampMs {
+			imp.lastSamples[k] = lastSample{labels: ts.Labels, timestampMs: timestampMs}
+		}
+	}
+}
+
+// A staleness marker is sent to each series, StaleAfter later than its last sample
+func (imp *importer) sendStaleMarkers() error {
+	if imp.options.StaleAfter <= 0 || len(imp.lastSamples) == 0 {
+		return nil
+	}
+
+	staleAfterMs := int64(imp.options.StaleAfter / time.Millisecond)
+	series := make(map[string]*prompb.TimeSeries, len(imp.lastSamples))
+	for k, last := range imp.lastSamples {
+		series[k] = &prompb.TimeSeries{
+			Labels: last.labels,
+			Samples: []*prompb.Sample{{
+				Timestamp: last.timestampMs + staleAfterMs,
+				Value:     math.Float64frombits(remote.StaleNaN),
+			}},
+		}
+	}
+
+	writeRequests := SplitWriteRequest(SeriesToWriteRequest(series), imp.options.Limits)
+	glog.V(1).Infof("%s: sending %d staleness markers in %d requests\n", util.FUNCTION_NAME_SHORT(),
+		len(series), len(writeRequests),
+	)
+	for _, writeRequest := range writeRequests {
+		start := time.Now()
+		if err := imp.store(writeRequest); err != nil {
+			return err
+		}
+		imp.summary.addSent(writeRequest, time.Since(start))
+	}
+	imp.summary.StaleMarkers += len(series)
+	imp.lastSamples = map[string]lastSample{}
+	return nil
+}
@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Reasons of dropping samples
+const (
+	DROP_UNSUPPORTED_TYPE = "unsupported_type"
+	DROP_FILTERED         = "filtered"
+	DROP_INVALID          = "invalid"
+
+	DROP_MISSING_TIMESTAMP = "missing_timestamp"
+)
+
+// ImportSummary is the result of an import, sent back in the push response
+type ImportSummary struct {
+	Families       int            `json:"families"`
+	Series         int            `json:"series"`
+	SamplesSent    int            `json:"samples_sent"`
+	Requests       int            `json:"requests"`
+	SamplesDropped map[string]int `json:"samples_dropped"`
+	MinTimestampMs int64          `json:"min_timestamp_ms"`
+	MaxTimestampMs int64          `json:"max_timestamp_ms"`
+	LatencySeconds float64        `json:"destination_latency_seconds"`
+	// Outcomes of samples without timestamp
+	MissingTimestamps map[string]int `json:"missing_timestamps"`
+	// Line numbers of samples without timestamp by outcome (text formats only, first maxMissingTimestampLines per outcome)
+	MissingTimestampLines map[string][]int `json:"missing_timestamp_lines,omitempty"`
+	TimeShiftMs           int64            `json:"time_shift_ms"`
+	// Count of samples merged to a sample with the same timestamp
+	DuplicatesResolved int `json:"duplicates_resolved"`
+	// Count of samples having the same timestamp as the last sample of the series, sent by an earlier flush (not merged)
+	CrossFlushDuplicates int `json:"cross_flush_duplicates"`
+	// Count of samples older than the last sample of the series, sent by an earlier flush (not sorted)
+	CrossFlushOutOfOrder int         `json:"cross_flush_out_of_order"`
+	Errors               []TextError `json:"errors,omitempty"`
+	// Names repaired by sanitizing
+	Renames []Rename `json:"renames,omitempty"`
+	// Different names sanitized to the same name
+	NameCollisions []NameCollision `json:"name_collisions,omitempty"`
+	// Count of sent staleness markers (included in SamplesSent)
+	StaleMarkers int `json:"stale_markers"`
+
+	familyNames map[string]bool
+	seriesKeys  map[string]bool
+	renameIndex map[string]int
+
+	sanitizedNames map[string]string
+	collisionIndex map[string]int
+}
+
+func NewImportSummary() *ImportSummary {
+	return &ImportSummary{
+		SamplesDropped: map[string]int{
+			DROP_UNSUPPORTED_TYPE: 0,
+			DROP_FILTERED:         0,
+			DROP_INVALID:          0,
+
+			DROP_MISSING_TIMESTAMP: 0,
+		},
+		MissingTimestamps: map[string]int{
+			TIMESTAMP_REJECTED:     0,
+			TIMESTAMP_RECEIVE_TIME: 0,
+			TIMESTAMP_BASE_TIME:    0,
+		},
+		familyNames: map[string]bool{},
+		seriesKeys:  map[string]bool{},
+		renameIndex: map[string]int{},
+
+		sanitizedNames: map[string]string{},
+		collisionIndex: map[string]int{},
+	}
+}
+
+func (s *ImportSummary) addFamily(name string) {
+	if !s.familyNames[name] {
+		s.familyNames[name] = true
+		s.Families++
+	}
+}
+
+// Series sent in more WriteRequests are counted once
+func (s *ImportSummary) addSeries(key string) {
+	if !s.seriesKeys[key] {
+		s.seriesKeys[key] = true
+		s.Series++
+	}
+}
+
+func (s *ImportSummary) addDropped(reason string, count int) {
+	s.SamplesDropped[reason] += count
+}
+
+func (s *ImportSummary) addMissingTimestamp(outcome string, count int) {
+	s.MissingTimestamps[outcome] += count
+}
+
+// Line numbers are shifted by lineOffset
+func (s *ImportSummary) addMissingTimestampLines(outcome string, lines []int, lineOffset int) {
+	if len(lines) == 0 {
+		return
+	}
+	if s.MissingTimestampLines == nil {
+		s.MissingTimestampLines = map[string][]int{}
+	}
+	for _, line := range lines {
+		if len(s.MissingTimestampLines[outcome]) >= maxMissingTimestampLines {
+			return
+		}
+		s.MissingTimestampLines[outcome] = append(s.MissingTimestampLines[outcome], line+lineOffset)
+	}
+}
+
+// Same renames are counted
+func (s *ImportSummary) addRename(kind string, from string, to string) {
+	key := kind + "\xff" + from
+	if r, has := s.renameIndex[key]; has {
+		s.Renames[r].Count++
+		return
+	}
+	s.renameIndex[key] = len(s.Renames)
+	s.Renames = append(s.Renames, Rename{Kind: kind, From: from, To: to, Count: 1})
+}
+
+// Names sanitized to the same name are collected, different names are reported as collision
+func (s *ImportSummary) addSanitized(kind string, from string, to string) {
+	key := kind + "\xff" + to
+	first, has := s.sanitizedNames[key]
+	if !has {
+		s.sanitizedNames[key] = from
+		return
+	}
+	if first == from {
+		return
+	}
+
+	c, has := s.collisionIndex[key]
+	if !has {
+		c = len(s.NameCollisions)
+		s.collisionIndex[key] = c
+		s.NameCollisions = append(s.NameCollisions, NameCollision{Kind: kind, Name: to, From: []string{first}})
+	}
+	for _, name := range s.NameCollisions[c].From {
+		if name == from {
+			return
+		}
+	}
+	s.NameCollisions[c].From = append(s.NameCollisions[c].From, from)
+	glog.Warningf("%s: %s names %q are sanitized to the same name %q\n", util.FUNCTION_NAME_SHORT(), kind, s.NameCollisions[c].From, to)
+}
+
+func (s *ImportSummary) addSent(writeRequest *prompb.WriteRequest, latency time.Duration) {
+	for _, ts := range writeRequest.Timeseries {
+		for _, sample := range ts.Samples {
+			if s.SamplesSent == 0 || sample.Timestamp < s.MinTimestampMs {
+				s.MinTimestampMs = sample.Timestamp
+			}
+			if s.SamplesSent == 0 || sample.Timestamp > s.MaxTimestampMs {
+				s.MaxTimestampMs = sample.Timestamp
+			}
+			s.SamplesSent++
+		}
+	}
+	s.Requests++
+	s.LatencySeconds += latency.Seconds()
+}
+
+// String is the one-line, human-readable version of the summary
+func (s *ImportSummary) String() string {
+	return fmt.Sprintf("families=%d series=%d samples_sent=%d requests=%d samples_dropped(%s) timestamps=[%s, %s] destination_latency=%s missing_timestamps(%s) time_shift=%s duplicates_resolved=%d cross_flush(duplicates=%d out_of_order=%d) stale_markers=%d",
+		s.Families, s.Series, s.SamplesSent, s.Requests, formatCounts(s.SamplesDropped),
+		FormatTimestampMs(s.MinTimestampMs), FormatTimestampMs(s.MaxTimestampMs),
+		time.Duration(s.LatencySeconds*float64(time.Second)), formatCounts(s.MissingTimestamps),
+		time.Duration(s.TimeShiftMs)*time.Millisecond, s.DuplicatesResolved,
+		s.CrossFlushDuplicates, s.CrossFlushOutOfOrder, s.StaleMarkers,
+	) + formatLines(" missing_timestamp_lines", s.MissingTimestampLines)
+}
+
+// Line numbers are formatted as " title(key=line,line ...)", ordered by key, empty if there is no line
+func formatLines(title string, keyLines map[string][]int) string {
+	if len(keyLines) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(keyLines))
+	for key := range keyLines {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines := make([]string, 0, len(keyLines[key]))
+		for _, line := range keyLines[key] {
+			lines = append(lines, strconv.Itoa(line))
+		}
+		pairs = append(pairs, key+"="+strings.Join(lines, ","))
+	}
+	return fmt.Sprintf("%s(%s)", title, strings.Join(pairs, " "))
+}
+
+// Counts are formatted as "key=count" list, ordered by key
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", key, counts[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// FormatTimestampMs formats a timestamp in ms as RFC 3339 (UTC)
+func FormatTimestampMs(timestampMs int64) string {
+	return time.Unix(0, timestampMs*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+}
+
+// Summary is written as JSON, or as text if the client prefers text/plain (see Accept header)
+func writeSummary(w http.ResponseWriter, req *http.Request, summary *ImportSummary) {
+	if prefersText(req) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, summary.String())
+		if len(summary.Errors) > 0 {
+			fmt.Fprintln(w, TextErrorsToString(summary.Errors))
+		}
+		for _, rename := range summary.Renames {
+			fmt.Fprintf(w, "%s %q renamed to %q (%d times)\n", rename.Kind, rename.From, rename.To, rename.Count)
+		}
+		for _, collision := range summary.NameCollisions {
+			fmt.Fprintf(w, "%s names %q are sanitized to the same name %q\n", collision.Kind, collision.From, collision.Name)
+		}
+		return
+	}
+
+	summaryJson, err := json.Marshal(summary)
+	if err != nil {
+		glog.Warningf("%s: JSON error: %+v\n", util.FUNCTION_NAME_SHORT(), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(summaryJson)
+	w.Write([]byte("\n"))
+}
+
+// The first known media type of Accept header is chosen, JSON is the default
+func prefersText(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/plain":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
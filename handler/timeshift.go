@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Time shift value, which maps the newest sample to now (optionally minus a duration, for example "now-5m")
+const TIME_SHIFT_NOW = "now"
+
+// TimeShift moves every sample by a fixed offset, or maps the newest sample to now minus Ago
+type TimeShift struct {
+	Offset time.Duration
+	Newest bool
+	Ago    time.Duration
+	NowMs  int64
+	// If it's set, the applied offset (in ms) is recorded in this label
+	Label string
+}
+
+// ParseTimeShift parses a duration (fixed offset, for example "-720h") or "now[-<duration>]"
+func ParseTimeShift(value string, label string) (TimeShift, error) {
+	timeShift := TimeShift{
+		NowMs: time.Now().UnixNano() / int64(time.Millisecond),
+		Label: label,
+	}
+	if value == "" {
+		return timeShift, nil
+	}
+
+	if strings.HasPrefix(value, TIME_SHIFT_NOW) {
+		timeShift.Newest = true
+		if ago := strings.TrimPrefix(value, TIME_SHIFT_NOW); ago != "" {
+			var err error
+			if !strings.HasPrefix(ago, "-") {
+				return timeShift, fmt.Errorf("invalid time shift: %q", value)
+			}
+			if timeShift.Ago, err = time.ParseDuration(ago[1:]); err != nil {
+				return timeShift, fmt.Errorf("invalid time shift: %q", value)
+			}
+		}
+		return timeShift, nil
+	}
+
+	var err error
+	if timeShift.Offset, err = time.ParseDuration(value); err != nil {
+		return timeShift, fmt.Errorf("invalid time shift: %q", value)
+	}
+	return timeShift, nil
+}
+
+// Enabled is true, if samples are shifted
+func (t TimeShift) Enabled() bool {
+	return t.Newest || t.Offset != 0
+}
+
+// Offset in ms, newestMs is the timestamp of the newest sample
+func (t TimeShift) offsetMs(newestMs int64) int64 {
+	if t.Newest {
+		return t.NowMs - int64(t.Ago/time.Millisecond) - newestMs
+	}
+	return int64(t.Offset / time.Millisecond)
+}
+
+// Samples are shifted, the applied offset is returned
+func (t TimeShift) apply(labelsToSeries map[string]*prompb.TimeSeries) int64 {
+	newestMs := int64(0)
+	first := true
+	for _, ts := range labelsToSeries {
+		for _, sample := range ts.Samples {
+			if first || sample.Timestamp > newestMs {
+				newestMs = sample.Timestamp
+				first = false
+			}
+		}
+	}
+
+	offsetMs := t.offsetMs(newestMs)
+	for _, ts := range labelsToSeries {
+		for _, sample := range ts.Samples {
+			sample.Timestamp += offsetMs
+		}
+		if t.Label != "" {
+			ts.Labels = setLabel(ts.Labels, t.Label, strconv.FormatInt(offsetMs, 10))
+		}
+	}
+	return offsetMs
+}
+
+// Value of the label is set, the label is inserted, if it's missing (labels are kept ordered by name)
+func setLabel(labels []*prompb.Label, name string, value string) []*prompb.Label {
+	for _, label := range labels {
+		if label.Name == name {
+			label.Value = value
+			return labels
+		}
+	}
+	labels = append(labels, &prompb.Label{Name: name, Value: value})
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pgillich/prometheus_text-to-remote_write/util"
+)
+
+// Policies for samples without timestamp
+const (
+	MISSING_TIMESTAMP_REJECT       = "reject"
+	MISSING_TIMESTAMP_RECEIVE_TIME = "receive-time"
+	MISSING_TIMESTAMP_BASE_TIME    = "base-time"
+)
+
+// Outcomes of samples without timestamp, see ImportSummary.MissingTimestamps
+const (
+	TIMESTAMP_REJECTED     = "rejected"
+	TIMESTAMP_RECEIVE_TIME = "receive_time"
+	TIMESTAMP_BASE_TIME    = "base_time"
+)
+
+// Max count of line numbers recorded per outcome, see ImportSummary.MissingTimestampLines
+const maxMissingTimestampLines = 100
+
+// Base time can be set by this header
+const HEADER_BASE_TIMESTAMP = "X-Base-Timestamp"
+
+// TimestampPolicy sets the timestamp of samples without timestamp
+type TimestampPolicy struct {
+	// MISSING_TIMESTAMP_REJECT, MISSING_TIMESTAMP_RECEIVE_TIME or MISSING_TIMESTAMP_BASE_TIME
+	Missing       string
+	ReceiveTimeMs int64
+	BaseTimeMs    int64
+}
+
+// NewTimestampPolicy builds the policy of the request.
+// The policy is set by service option, which is overridden by the query parameter.
+// Base time is set by header or query parameter (in ms or RFC 3339), the base-time policy is chosen, if it's set.
+func NewTimestampPolicy(req *http.Request, missing string, paramMissing string, paramBase string) (TimestampPolicy, error) {
+	policy := TimestampPolicy{
+		Missing:       missing,
+		ReceiveTimeMs: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	baseTime := req.Header.Get(HEADER_BASE_TIMESTAMP)
+	if value := req.URL.Query().Get(paramBase); value != "" {
+		baseTime = value
+	}
+	if baseTime != "" {
+		var err error
+		if policy.BaseTimeMs, err = parseTimestampMs(baseTime); err != nil {
+			return policy, fmt.Errorf("invalid base timestamp: %q", baseTime)
+		}
+		policy.Missing = MISSING_TIMESTAMP_BASE_TIME
+	}
+
+	if value := req.URL.Query().Get(paramMissing); value != "" {
+		policy.Missing = value
+	}
+
+	switch policy.Missing {
+	case MISSING_TIMESTAMP_REJECT, MISSING_TIMESTAMP_RECEIVE_TIME:
+	case MISSING_TIMESTAMP_BASE_TIME:
+		if baseTime == "" {
+			return policy, fmt.Errorf("missing base timestamp for %s policy", MISSING_TIMESTAMP_BASE_TIME)
+		}
+	default:
+		return policy, fmt.Errorf("invalid missing timestamp policy: %q", policy.Missing)
+	}
+
+	return policy, nil
+}
+
+// Milliseconds since epoch or RFC 3339
+func parseTimestampMs(value string) (int64, error) {
+	if timestampMs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return timestampMs, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// Samples without timestamp are stamped or removed by the policy, outcomes are counted in the summary
+func (p TimestampPolicy) apply(metricFamilies map[string]*dto.MetricFamily, summary *ImportSummary) {
+	for _, m := range metricFamilies {
+		metrics := m.Metric[:0]
+		for _, s := range m.GetMetric() {
+			if s.TimestampMs != nil {
+				metrics = append(metrics, s)
+				continue
+			}
+
+			samples := metricSamples(m.GetType(), s)
+			switch p.Missing {
+			case MISSING_TIMESTAMP_REJECT:
+				glog.V(1).Infof("%s: missing timestamp: %s %v\n", util.FUNCTION_NAME_SHORT(), m.GetName(), s.GetLabel())
+				summary.addMissingTimestamp(TIMESTAMP_REJECTED, samples)
+				summary.addDropped(DROP_MISSING_TIMESTAMP, samples)
+				continue
+			case MISSING_TIMESTAMP_BASE_TIME:
+				s.TimestampMs = proto.Int64(p.BaseTimeMs)
+				summary.addMissingTimestamp(TIMESTAMP_BASE_TIME, samples)
+			default:
+				s.TimestampMs = proto.Int64(p.ReceiveTimeMs)
+				summary.addMissingTimestamp(TIMESTAMP_RECEIVE_TIME, samples)
+			}
+			metrics = append(metrics, s)
+		}
+		m.Metric = metrics
+	}
+}
+
+// Outcome of samples without timestamp by the policy (see apply)
+func (p TimestampPolicy) outcome() string {
+	switch p.Missing {
+	case MISSING_TIMESTAMP_REJECT:
+		return TIMESTAMP_REJECTED
+	case MISSING_TIMESTAMP_BASE_TIME:
+		return TIMESTAMP_BASE_TIME
+	}
+	return TIMESTAMP_RECEIVE_TIME
+}
+
+// Line numbers of samples without timestamp in the text exposition format, invalid lines (see textErrors) are skipped.
+// A histogram or summary line is counted, if no line of its series has timestamp, because the text parser merges them.
+func missingTimestampLines(text []byte, textErrors []TextError) []int {
+	errorLines := map[int]bool{}
+	for _, e := range textErrors {
+		errorLines[e.Line] = true
+	}
+
+	type missingLine struct {
+		line int
+		key  string
+	}
+	missingLines := []missingLine{}
+	familyTypes := map[string]string{}
+	timestampedSeries := map[string]bool{}
+	for l, line := range bytes.Split(text, []byte("\n")) {
+		line := strings.TrimSpace(string(line))
+		if line == "" || errorLines[l+1] {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if parts := strings.Fields(line); len(parts) >= 4 && parts[1] == "TYPE" {
+				familyTypes[parts[2]] = strings.ToLower(parts[3])
+			}
+			continue
+		}
+
+		name, labels, rest, err := splitSampleLine(line)
+		fields := strings.Fields(rest)
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		key := ""
+		if family, sampleLabel := aggregatedFamily(name, familyTypes); family != "" {
+			if seriesLabels, ok := labelSetKey(labels, sampleLabel); ok {
+				key = family + "\xff" + seriesLabels
+			}
+		}
+		if len(fields) >= 2 {
+			if key != "" {
+				timestampedSeries[key] = true
+			}
+			continue
+		}
+		missingLines = append(missingLines, missingLine{line: l + 1, key: key})
+	}
+
+	lines := []int{}
+	for _, missing := range missingLines {
+		if !timestampedSeries[missing.key] {
+			lines = append(lines, missing.line)
+		}
+	}
+	return lines
+}
+
+// Count of samples (lines of the text format) of a metric, see mergeSummary and mergeHistogram
+func metricSamples(metricType dto.MetricType, s *dto.Metric) int {
+	switch metricType {
+	case dto.MetricType_SUMMARY:
+		return len(s.GetSummary().GetQuantile()) + 2
+	case dto.MetricType_HISTOGRAM:
+		return len(s.GetHistogram().GetBucket()) + 2
+	}
+	return 1
+}
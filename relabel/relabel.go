@@ -0,0 +1,311 @@
+// Package relabel implements Prometheus relabel_configs on remote_write labels.
+// Idea from github.com/prometheus/prometheus/config/config.go:RelabelConfig
+// and github.com/prometheus/prometheus/relabel/relabel.go
+package relabel
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var relabelTarget = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+
+// Action is the action to be performed on relabeling
+type Action string
+
+const (
+	// Replace performs a regex replacement
+	Replace Action = "replace"
+	// Keep drops the series, if the regex does not match
+	Keep Action = "keep"
+	// Drop drops the series, if the regex matches
+	Drop Action = "drop"
+	// HashMod sets a label to the modulus of a hash of the source label values
+	HashMod Action = "hashmod"
+	// LabelMap copies labels to other labelnames based on a regex
+	LabelMap Action = "labelmap"
+	// LabelDrop drops the labels matching the regex
+	LabelDrop Action = "labeldrop"
+	// LabelKeep drops the labels not matching the regex
+	LabelKeep Action = "labelkeep"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (a *Action) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := Action(strings.ToLower(s)); act {
+	case Replace, Keep, Drop, HashMod, LabelMap, LabelDrop, LabelKeep:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown relabel action %q", s)
+}
+
+// Regexp is an anchored regular expression, it's marshaled by the original string
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp creates a new anchored Regexp
+func NewRegexp(s string) (Regexp, error) {
+	regex, err := regexp.Compile("^(?:" + s + ")$")
+	return Regexp{Regexp: regex, original: s}, err
+}
+
+// MustNewRegexp works like NewRegexp, but panics, if the regular expression does not compile
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return err
+	}
+	*re = r
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.original != "" {
+		return re.original, nil
+	}
+	return nil, nil
+}
+
+// Config is the configuration of a relabeling step, same to Prometheus relabel_config
+type Config struct {
+	// A list of labels from which values are taken and concatenated with the configured separator in order
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	// Separator is the string between concatenated values from the source labels
+	Separator string `yaml:"separator,omitempty"`
+	// Regex against which the concatenation is matched
+	Regex Regexp `yaml:"regex,omitempty"`
+	// Modulus to take of the hash of concatenated values from the source labels
+	Modulus uint64 `yaml:"modulus,omitempty"`
+	// TargetLabel is the label to which the resulting string is written in a replacement
+	// (regex capture groups are available)
+	TargetLabel string `yaml:"target_label,omitempty"`
+	// Replacement is the regex replacement pattern to be used
+	Replacement string `yaml:"replacement,omitempty"`
+	// Action is the action to be performed for the relabeling
+	Action Action `yaml:"action,omitempty"`
+}
+
+// DefaultConfig is the default relabeling configuration
+var DefaultConfig = Config{
+	Action:      Replace,
+	Separator:   ";",
+	Regex:       MustNewRegexp("(.*)"),
+	Replacement: "$1",
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Regex.Regexp == nil {
+		c.Regex = MustNewRegexp("")
+	}
+	if c.Modulus == 0 && c.Action == HashMod {
+		return fmt.Errorf("relabel configuration for hashmod requires non-zero modulus")
+	}
+	if (c.Action == Replace || c.Action == HashMod) && c.TargetLabel == "" {
+		return fmt.Errorf("relabel configuration for %s action requires 'target_label' value", c.Action)
+	}
+	if c.Action == Replace && !relabelTarget.MatchString(c.TargetLabel) {
+		return fmt.Errorf("%q is invalid 'target_label' for %s action", c.TargetLabel, c.Action)
+	}
+	if c.Action == LabelMap && !relabelTarget.MatchString(c.Replacement) {
+		return fmt.Errorf("%q is invalid 'replacement' for %s action", c.Replacement, c.Action)
+	}
+	if c.Action == HashMod && !model.LabelName(c.TargetLabel).IsValid() {
+		return fmt.Errorf("%q is invalid 'target_label' for %s action", c.TargetLabel, c.Action)
+	}
+	if c.Action == LabelDrop || c.Action == LabelKeep {
+		if c.SourceLabels != nil ||
+			c.TargetLabel != DefaultConfig.TargetLabel ||
+			c.Modulus != DefaultConfig.Modulus ||
+			c.Separator != DefaultConfig.Separator ||
+			c.Replacement != DefaultConfig.Replacement {
+			return fmt.Errorf("%s action requires only 'regex', and no other fields", c.Action)
+		}
+	}
+	return nil
+}
+
+// Load parses a YAML list of relabel configs.
+// The list can be the value of "relabel_configs" key, as in Prometheus configuration.
+func Load(data []byte) ([]*Config, error) {
+	var root interface{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var configs []*Config
+	if _, isList := root.([]interface{}); isList || root == nil {
+		if err := yaml.UnmarshalStrict(data, &configs); err != nil {
+			return nil, err
+		}
+		return configs, nil
+	}
+
+	file := struct {
+		RelabelConfigs []*Config `yaml:"relabel_configs"`
+	}{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	return file.RelabelConfigs, nil
+}
+
+// LoadFile parses a YAML file of relabel configs, see Load
+func LoadFile(filename string) ([]*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file %s: %v", filename, err)
+	}
+	return configs, nil
+}
+
+// Process returns the relabeled labels (ordered by name), or nil, if the series is dropped
+func Process(labels []*prompb.Label, configs ...*Config) []*prompb.Label {
+	lset := make(map[string]string, len(labels))
+	for _, label := range labels {
+		lset[label.Name] = label.Value
+	}
+
+	for _, config := range configs {
+		if lset = relabel(lset, config); lset == nil {
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(lset))
+	for name := range lset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	relabeled := make([]*prompb.Label, 0, len(names))
+	for _, name := range names {
+		relabeled = append(relabeled, &prompb.Label{Name: name, Value: lset[name]})
+	}
+	return relabeled
+}
+
+func relabel(lset map[string]string, config *Config) map[string]string {
+	values := make([]string, 0, len(config.SourceLabels))
+	for _, name := range config.SourceLabels {
+		values = append(values, lset[name])
+	}
+	val := strings.Join(values, config.Separator)
+
+	switch config.Action {
+	case Drop:
+		if config.Regex.MatchString(val) {
+			return nil
+		}
+	case Keep:
+		if !config.Regex.MatchString(val) {
+			return nil
+		}
+	case Replace:
+		indexes := config.Regex.FindStringSubmatchIndex(val)
+		// If there is no match no replacement must take place
+		if indexes == nil {
+			break
+		}
+		target := model.LabelName(config.Regex.ExpandString([]byte{}, config.TargetLabel, val, indexes))
+		if !target.IsValid() {
+			delete(lset, config.TargetLabel)
+			break
+		}
+		res := config.Regex.ExpandString([]byte{}, config.Replacement, val, indexes)
+		if len(res) == 0 {
+			delete(lset, config.TargetLabel)
+			break
+		}
+		lset[string(target)] = string(res)
+	case HashMod:
+		mod := sum64(md5.Sum([]byte(val))) % config.Modulus
+		setLabel(lset, config.TargetLabel, fmt.Sprintf("%d", mod))
+	case LabelMap:
+		mapped := map[string]string{}
+		for name, value := range lset {
+			if config.Regex.MatchString(name) {
+				mapped[config.Regex.ReplaceAllString(name, config.Replacement)] = value
+			}
+		}
+		for name, value := range mapped {
+			setLabel(lset, name, value)
+		}
+	case LabelDrop:
+		for name := range lset {
+			if config.Regex.MatchString(name) {
+				delete(lset, name)
+			}
+		}
+	case LabelKeep:
+		for name := range lset {
+			if !config.Regex.MatchString(name) {
+				delete(lset, name)
+			}
+		}
+	default:
+		panic(fmt.Errorf("relabel: unknown relabel action type %q", config.Action))
+	}
+
+	return lset
+}
+
+// Label with empty value is deleted
+func setLabel(lset map[string]string, name string, value string) {
+	if value == "" {
+		delete(lset, name)
+		return
+	}
+	lset[name] = value
+}
+
+// sum64 sums the md5 hash to an uint64
+func sum64(hash [md5.Size]byte) uint64 {
+	var s uint64
+
+	for i, b := range hash {
+		shift := uint64((md5.Size - 1 - i) * 8)
+
+		s |= uint64(b) << shift
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+package relabel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// testLabels builds labels from name-value pairs
+func testLabels(pairs ...string) []*prompb.Label {
+	labels := make([]*prompb.Label, 0, len(pairs)/2)
+	for p := 0; p+1 < len(pairs); p += 2 {
+		labels = append(labels, &prompb.Label{Name: pairs[p], Value: pairs[p+1]})
+	}
+	return labels
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []*prompb.Label
+		configs  []*Config
+		expected []*prompb.Label
+	}{
+		{
+			name:  "replace",
+			input: testLabels("a", "foo", "b", "bar", "c", "baz"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("f(.*)"),
+				TargetLabel:  "d",
+				Separator:    ";",
+				Replacement:  "ch${1}-ch${1}",
+				Action:       Replace,
+			}},
+			expected: testLabels("a", "foo", "b", "bar", "c", "baz", "d", "choo-choo"),
+		},
+		{
+			name:  "replace by more source labels",
+			input: testLabels("a", "foo", "b", "bar", "c", "baz"),
+			configs: []*Config{{
+				SourceLabels: []string{"a", "b"},
+				Regex:        MustNewRegexp("f(.*);(.*)r"),
+				TargetLabel:  "a",
+				Separator:    ";",
+				Replacement:  "b${1}${2}m",
+				Action:       Replace,
+			}},
+			expected: testLabels("a", "boobam", "b", "bar", "c", "baz"),
+		},
+		{
+			name:  "replace without match",
+			input: testLabels("a", "foo"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("bar"),
+				TargetLabel:  "b",
+				Separator:    ";",
+				Replacement:  "$1",
+				Action:       Replace,
+			}},
+			expected: testLabels("a", "foo"),
+		},
+		{
+			name:  "replace by empty value",
+			input: testLabels("a", "foo", "b", "bar"),
+			configs: []*Config{{
+				SourceLabels: []string{"c"},
+				Regex:        MustNewRegexp("(.*)"),
+				TargetLabel:  "b",
+				Separator:    ";",
+				Replacement:  "$1",
+				Action:       Replace,
+			}},
+			expected: testLabels("a", "foo"),
+		},
+		{
+			name:  "replace to invalid target",
+			input: testLabels("a", "some-name-value"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("some-([^-]+)-([^,]+)"),
+				TargetLabel:  "${1}-${2}",
+				Separator:    ";",
+				Replacement:  "${2}",
+				Action:       Replace,
+			}},
+			expected: testLabels("a", "some-name-value"),
+		},
+		{
+			name:  "keep",
+			input: testLabels("a", "foo"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("f.*"),
+				Action:       Keep,
+			}},
+			expected: testLabels("a", "foo"),
+		},
+		{
+			name:  "keep without match",
+			input: testLabels("a", "foo"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("no-match"),
+				Action:       Keep,
+			}},
+			expected: nil,
+		},
+		{
+			name:  "drop",
+			input: testLabels("a", "foo"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("f.*"),
+				Action:       Drop,
+			}},
+			expected: nil,
+		},
+		{
+			name:  "drop without match",
+			input: testLabels("a", "foo"),
+			configs: []*Config{{
+				SourceLabels: []string{"a"},
+				Regex:        MustNewRegexp("no-match"),
+				Action:       Drop,
+			}},
+			expected: testLabels("a", "foo"),
+		},
+		{
+			name:  "hashmod",
+			input: testLabels("a", "foo", "b", "bar", "c", "baz"),
+			configs: []*Config{{
+				SourceLabels: []string{"c"},
+				TargetLabel:  "d",
+				Separator:    ";",
+				Action:       HashMod,
+				Modulus:      1000,
+			}},
+			expected: testLabels("a", "foo", "b", "bar", "c", "baz", "d", "976"),
+		},
+		{
+			name:  "labelmap",
+			input: testLabels("a", "foo", "b", "bar", "c", "baz"),
+			configs: []*Config{{
+				Regex:       MustNewRegexp("(b.*)"),
+				Replacement: "bar_${1}",
+				Action:      LabelMap,
+			}},
+			expected: testLabels("a", "foo", "b", "bar", "bar_b", "bar", "c", "baz"),
+		},
+		{
+			name:  "labelmap overriding",
+			input: testLabels("__meta_a", "foo", "a", "bar"),
+			configs: []*Config{{
+				Regex:       MustNewRegexp("__meta_(.+)"),
+				Replacement: "${1}",
+				Action:      LabelMap,
+			}},
+			expected: testLabels("__meta_a", "foo", "a", "foo"),
+		},
+		{
+			name:  "labeldrop",
+			input: testLabels("a", "foo", "b", "bar", "c", "baz"),
+			configs: []*Config{{
+				Regex:  MustNewRegexp("(b|c)"),
+				Action: LabelDrop,
+			}},
+			expected: testLabels("a", "foo"),
+		},
+		{
+			name:  "labelkeep",
+			input: testLabels("a", "foo", "b", "bar", "c", "baz"),
+			configs: []*Config{{
+				Regex:  MustNewRegexp("(b|c)"),
+				Action: LabelKeep,
+			}},
+			expected: testLabels("b", "bar", "c", "baz"),
+		},
+		{
+			name:  "more configs",
+			input: testLabels("a", "foo", "b", "bar"),
+			configs: []*Config{
+				{
+					SourceLabels: []string{"a"},
+					Regex:        MustNewRegexp("(.*)"),
+					TargetLabel:  "c",
+					Separator:    ";",
+					Replacement:  "${1}_copy",
+					Action:       Replace,
+				},
+				{
+					Regex:  MustNewRegexp("a"),
+					Action: LabelDrop,
+				},
+			},
+			expected: testLabels("b", "bar", "c", "foo_copy"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if relabeled := Process(test.input, test.configs...); !reflect.DeepEqual(relabeled, test.expected) {
+				t.Errorf("relabeled %v, expected %v", relabeled, test.expected)
+			}
+		})
+	}
+}
@@ -64,6 +64,36 @@ type recoverableError struct {
 	error
 }
 
+// ADDED
+// IsRecoverable tells the error may disappear by retrying (network error or 5xx HTTP status)
+func IsRecoverable(err error) bool {
+	_, ok := err.(recoverableError)
+	return ok
+}
+
+// ADDED
+// HTTPError is returned if the HTTP endpoint responded non-2xx status
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Msg        string
+}
+
+func (e HTTPError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %s: %s", e.Status, e.Msg)
+}
+
+// ADDED
+// HTTPErrorOf returns the HTTPError, if err is (or wraps) HTTPError
+func HTTPErrorOf(err error) (HTTPError, bool) {
+	if rErr, ok := err.(recoverableError); ok {
+		err = rErr.error
+	}
+	httpErr, ok := err.(HTTPError)
+	return httpErr, ok
+}
+
+// MODIFIED
 // Store sends a batch of samples to the HTTP endpoint.
 func (c *Client) Store(ctx context.Context, req *prompb.WriteRequest) error {
 	data, err := proto.Marshal(req)
@@ -100,7 +130,11 @@ func (c *Client) Store(ctx context.Context, req *prompb.WriteRequest) error {
 		if scanner.Scan() {
 			line = scanner.Text()
 		}
-		err = fmt.Errorf("server returned HTTP status %s: %s", httpResp.Status, line)
+		err = HTTPError{
+			StatusCode: httpResp.StatusCode,
+			Status:     httpResp.Status,
+			Msg:        line,
+		}
 	}
 	if httpResp.StatusCode/100 == 5 {
 		return recoverableError{err}
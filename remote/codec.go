@@ -3,8 +3,13 @@ package remote
 // from github.com/prometheus/prometheus/storage/remote/codec.go
 
 import (
+	"io"
+	"io/ioutil"
 	"sort"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/prompb"
 )
@@ -23,3 +28,26 @@ func MetricToLabelProtos(metric model.Metric) []*prompb.Label {
 	})
 	return labels
 }
+
+// ADDED
+// DecodeWriteRequest from an io.Reader into a prompb.WriteRequest, handling
+// snappy decompression (if compressed is true).
+func DecodeWriteRequest(r io.Reader, compressed bool) (*prompb.WriteRequest, error) {
+	reqBuf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if compressed {
+		if reqBuf, err = snappy.Decode(nil, reqBuf); err != nil {
+			return nil, err
+		}
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(reqBuf, &req); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
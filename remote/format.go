@@ -0,0 +1,123 @@
+package remote
+
+// ADDED
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// StaleNaN is the bit pattern of the Prometheus staleness marker, see github.com/prometheus/prometheus/pkg/value
+const StaleNaN uint64 = 0x7ff0000000000002
+
+// WriteText writes the series of the WriteRequest in text exposition format, one sample per line with timestamp.
+// Staleness markers are written as NaN.
+func WriteText(w io.Writer, req *prompb.WriteRequest) error {
+	bw := bufio.NewWriter(w)
+	for _, ts := range req.Timeseries {
+		series := SeriesText(ts.Labels)
+		for _, sample := range ts.Samples {
+			bw.WriteString(series)
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(sample.Value))
+			bw.WriteByte(' ')
+			bw.WriteString(strconv.FormatInt(sample.Timestamp, 10))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// SeriesText returns the metric name and the other labels ordered by name, for example: name{a="1",b="2"}
+func SeriesText(labels []*prompb.Label) string {
+	sorted := make([]*prompb.Label, 0, len(labels))
+	name := ""
+	for _, label := range labels {
+		if label.Name == model.MetricNameLabel {
+			name = label.Value
+			continue
+		}
+		sorted = append(sorted, label)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	if len(sorted) == 0 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(sorted))
+	for _, label := range sorted {
+		pairs = append(pairs, label.Name+`="`+labelValueReplacer.Replace(label.Value)+`"`)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Same to the text exposition format
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, +1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// JSONWriteRequest is the JSON representation of WriteRequest, same to the JSON tags of prompb,
+// but non-finite values (for example staleness markers) are written as strings
+type JSONWriteRequest struct {
+	Timeseries []JSONTimeSeries `json:"timeseries,omitempty"`
+}
+
+// JSONTimeSeries is the JSON representation of TimeSeries
+type JSONTimeSeries struct {
+	Labels  []*prompb.Label `json:"labels,omitempty"`
+	Samples []JSONSample    `json:"samples,omitempty"`
+}
+
+// JSONSample is the JSON representation of Sample
+type JSONSample struct {
+	Value     JSONValue `json:"value,omitempty"`
+	Timestamp int64     `json:"timestamp,omitempty"`
+}
+
+// JSONValue is written as a string, if it's not finite ("StaleNaN", "NaN", "+Inf" or "-Inf")
+type JSONValue float64
+
+// MarshalJSON implements json.Marshaler
+func (v JSONValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.Float64bits(f) == StaleNaN {
+		return []byte(`"StaleNaN"`), nil
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte(`"` + formatValue(f) + `"`), nil
+	}
+	return json.Marshal(f)
+}
+
+// NewJSONWriteRequest converts the WriteRequest to its JSON representation
+func NewJSONWriteRequest(req *prompb.WriteRequest) JSONWriteRequest {
+	jsonRequest := JSONWriteRequest{Timeseries: make([]JSONTimeSeries, 0, len(req.Timeseries))}
+	for _, ts := range req.Timeseries {
+		samples := make([]JSONSample, 0, len(ts.Samples))
+		for _, sample := range ts.Samples {
+			samples = append(samples, JSONSample{Value: JSONValue(sample.Value), Timestamp: sample.Timestamp})
+		}
+		jsonRequest.Timeseries = append(jsonRequest.Timeseries, JSONTimeSeries{Labels: ts.Labels, Samples: samples})
+	}
+	return jsonRequest
+}